@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// fileModeValue is a pflag.Value for file permissions given in octal notation.
+type fileModeValue os.FileMode
+
+var _ pflag.Value = (*fileModeValue)(nil)
+
+func newFileModeValue(val os.FileMode, p *os.FileMode) *fileModeValue {
+	*p = val
+	return (*fileModeValue)(p)
+}
+
+func (m *fileModeValue) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return err
+	}
+
+	if v&^uint64(os.ModePerm) != 0 {
+		return fmt.Errorf("invalid permission bits %s", s)
+	}
+
+	*m = fileModeValue(v)
+
+	return nil
+}
+
+func (m *fileModeValue) Type() string {
+	return "mode"
+}
+
+func (m *fileModeValue) String() string {
+	return fmt.Sprintf("%04o", uint32(*m))
+}
+
+// byteSizeValue is a pflag.Value for sizes in bytes with an optional binary unit, e.g. 512M or 5GiB.
+type byteSizeValue int64
+
+var _ pflag.Value = (*byteSizeValue)(nil)
+
+// byte size units, powers of 1024.
+var byteSizeUnits = []string{"", "K", "M", "G", "T"}
+
+func newByteSizeValue(val int64, p *int64) *byteSizeValue {
+	*p = val
+	return (*byteSizeValue)(p)
+}
+
+func (b *byteSizeValue) Set(s string) error {
+	num := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B"), "I")
+
+	shift := uint(0)
+
+	for i := len(byteSizeUnits) - 1; i > 0; i-- {
+		if strings.HasSuffix(num, byteSizeUnits[i]) {
+			num, shift = strings.TrimSuffix(num, byteSizeUnits[i]), uint(10*i)
+
+			break
+		}
+	}
+
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v < 0 || v > math.MaxInt64>>shift {
+		return fmt.Errorf("invalid size %s", s)
+	}
+
+	*b = byteSizeValue(v << shift)
+
+	return nil
+}
+
+func (b *byteSizeValue) Type() string {
+	return "size"
+}
+
+func (b *byteSizeValue) String() string {
+	v, i := int64(*b), 0
+
+	for v != 0 && v%1024 == 0 && i < len(byteSizeUnits)-1 {
+		v /= 1024
+		i++
+	}
+
+	return fmt.Sprintf("%d%s", v, byteSizeUnits[i])
+}
+
+// varsValue is a pflag.Value for variables given as key=value, the flag can be repeated.
+type varsValue map[string]string
+
+var _ pflag.Value = (*varsValue)(nil)
+
+func newVarsValue(p *map[string]string) *varsValue {
+	if *p == nil {
+		*p = make(map[string]string)
+	}
+
+	return (*varsValue)(p)
+}
+
+func (v *varsValue) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("invalid variable %s, expected key=value", s)
+	}
+
+	(*v)[kv[0]] = kv[1]
+
+	return nil
+}
+
+func (v *varsValue) Type() string {
+	return "key=value"
+}
+
+func (v *varsValue) String() string {
+	keys := make([]string, 0, len(*v))
+	for k := range *v {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	// values are not shown, they may be secrets
+	return strings.Join(keys, ",")
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	syslog "log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/aweris/postgres-data-dump/database"
 	"github.com/aweris/postgres-data-dump/dump"
 	"github.com/aweris/postgres-data-dump/internal/helpers"
 	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/aweris/postgres-data-dump/internal/watchdog"
 	"github.com/aweris/postgres-data-dump/storage"
 	"github.com/aweris/postgres-data-dump/storage/backend"
 	"github.com/aweris/postgres-data-dump/storage/backend/fs"
+	"github.com/aweris/postgres-data-dump/storage/backend/oci"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 )
 
@@ -25,6 +30,32 @@ var (
 	date    = "unknown"
 )
 
+// exitPartialFailure is the exit code used when the dump is written to some, but not all, destinations.
+const exitPartialFailure = 2
+
+// commands.
+const (
+	commandDump     = "dump"
+	commandPull     = "pull"
+	commandCopy     = "copy"
+	commandRestore  = "restore"
+	commandManifest = "manifest"
+	commandInit     = "init"
+)
+
+const usage = `Usage of pdd:
+  pdd [dump] [flags]     dumps the database to the storage backends
+  pdd pull <key> [flags] writes the dump stored at key to the output
+  pdd copy [flags]       copies the database into the target database
+  pdd restore <name> [flags]
+                         restores the csv or binary dump stored under name into the target database
+  pdd manifest schema [flags]
+                         writes the JSON Schema of manifest files to the output
+  pdd init [flags]       writes a starter manifest of the database to the manifest file, if it doesn't exist
+
+Flags:
+%s`
+
 func main() {
 	var (
 		// logging
@@ -35,12 +66,31 @@ func main() {
 		// database
 		dbc = database.Config{}
 
+		// target database
+		tdbc = database.Config{}
+
 		// dump
 		dc = dump.Config{}
 
+		// storage
+		sc = storage.Config{}
+
+		// timeouts
+		wc = watchdog.Config{}
+
 		// backend
 		bc = backend.Config{}
 
+		// filesystem destinations share the permissions
+		fsRoots []string
+		fsc     = fs.Config{}
+
+		// pull
+		output string
+
+		// split
+		maxObjectSize int64
+
 		// other
 		showVersion bool
 	)
@@ -50,6 +100,10 @@ func main() {
 	// no need to sort flags in help
 	flag.SortFlags = false
 
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, flag.FlagUsages())
+	}
+
 	// logging flags
 	flag.StringVar(&logLevel, "log-level", log.LevelError, "log filtering level. ('error', 'warn', 'info', 'debug')")
 	flag.StringVar(&logFormat, "log-format", log.FormatFmt, "log format to use. ('fmt', 'json')")
@@ -64,14 +118,52 @@ func main() {
 	flag.DurationVar(&dbc.ReadTimeout, "read-timeout", database.DefaultReadTimeout, "Timeout for socket reads. If reached, commands will fail")
 	flag.IntVar(&dbc.MaxRetries, "max-retry", database.DefaultMaxRetries, "Maximum number of retries before giving up.")
 
+	// target database flags
+	flag.StringVar(&tdbc.Addr, "target-addr", database.DefaultAddr, "Target database TCP host:port or Unix socket, used by copy and restore")
+	flag.StringVar(&tdbc.Database, "target-database", database.DefaultDatabase, "Target database name, used by copy and restore")
+	flag.StringVar(&tdbc.User, "target-user", database.DefaultUser, "Target database user, used by copy and restore")
+	flag.StringVar(&tdbc.Password, "target-pass", database.DefaultPassword, "Target database password, used by copy and restore")
+
 	// dump flags
 	flag.StringVar(&dc.ManifestFile, "manifest-file", dump.DefaultManifestFile, "Path to manifest file")
+	flag.StringVar(&dc.Format, "format", dump.DefaultFormat, "Dump format ('plain', 'custom', 'csv', 'binary', 'jsonl', 'parquet', 'sqlite', 'inserts', 'tar')")
+	flag.Var(newVarsValue(&dc.Vars), "var", "Manifest variable as key=value overriding the manifest, can be repeated")
+	flag.BoolVar(&dc.Deterministic, "deterministic", false, "Order rows by primary key and normalize session settings so dumps of unchanged data are identical")
+	flag.BoolVar(&dc.Truncate, "truncate", false, "Truncate target tables before loading, used by copy and restore")
+	flag.IntVar(&dc.RowsPerInsert, "rows-per-insert", dump.DefaultRowsPerInsert, "Number of rows in an INSERT statement, used by inserts")
+	flag.BoolVar(&dc.ColumnInserts, "column-inserts", false, "Write column names in INSERT statements, used by inserts")
+	flag.BoolVar(&dc.OnConflictDoNothing, "on-conflict-do-nothing", false, "Add ON CONFLICT DO NOTHING to INSERT statements, used by inserts")
+	flag.IntVar(&dc.ParquetRowGroupSize, "parquet-row-group-size", dump.DefaultParquetRowGroupSize, "Number of rows in a row group of parquet files")
+	flag.StringVar(&dc.ParquetCompression, "parquet-compression", dump.DefaultParquetCompression, "Compression of parquet files ('none', 'snappy', 'zstd')")
+	flag.StringVar(&dc.TarCompression, "tar-compression", dump.DefaultTarCompression, "Compression of tar bundles ('none', 'gzip', 'zstd')")
+	flag.StringVar(&dc.SpoolDir, "spool-dir", "", "Directory of temporary files holding tar bundle table data and sqlite databases, defaults to the system temporary directory")
+
+	// storage flags
+	flag.StringVar(&sc.Policy, "storage-policy", storage.DefaultPolicy, "policy for multiple backends ('all-must-succeed', 'at-least-one')")
+	flag.Var(newByteSizeValue(0, &maxObjectSize), "max-object-size", "split objects into parts of at most this size, e.g. 5G, zero means no limit")
+
+	// timeout flags
+	flag.DurationVar(&wc.Timeout, "timeout", watchdog.DefaultTimeout, "Total time allowed for the dump, zero means no limit")
+	flag.DurationVar(&wc.IdleTimeout, "idle-timeout", watchdog.DefaultIdleTimeout, "Time allowed without any dump progress, zero means no limit")
 
 	// backend
-	flag.StringVar(&bc.Type, "backend", backend.FileSystem, "storage backend to use (filesystem)")
+	flag.StringSliceVar(&bc.Types, "backend", []string{backend.FileSystem}, "storage backends to use, comma separated (filesystem, oci)")
 
 	// backend filesystem
-	flag.StringVar(&bc.FileSystem.Root, "filesystem-root", fs.DefaultRoot, "local filesystem root directory")
+	flag.StringSliceVar(&fsRoots, "filesystem-root", []string{fs.DefaultRoot}, "local filesystem root directories, each one is a destination, can be repeated")
+	flag.Var(newFileModeValue(fs.DefaultFileMode, &fsc.FileMode), "filesystem-file-mode", "permissions of the written files, in octal")
+	flag.Var(newFileModeValue(fs.DefaultDirMode, &fsc.DirMode), "filesystem-dir-mode", "permissions of the created directories, in octal")
+
+	// backend oci
+	flag.StringVar(&bc.OCI.Repository, "oci-repository", "", "oci repository including registry host, e.g. registry.example.com/team/sample-db")
+	flag.StringVar(&bc.OCI.TagTemplate, "oci-tag", oci.DefaultTagTemplate, "oci tag template, has access to .Key, .Name and .Time")
+	flag.StringVar(&bc.OCI.MediaType, "oci-media-type", oci.DefaultMediaType, "oci media type of the dump layer")
+	flag.StringVar(&bc.OCI.Username, "oci-username", "", "oci registry user, docker credentials are used if not given")
+	flag.StringVar(&bc.OCI.Password, "oci-password", "", "oci registry password")
+	flag.BoolVar(&bc.OCI.PlainHTTP, "oci-plain-http", false, "use plain http to connect oci registry")
+
+	// pull flags
+	flag.StringVarP(&output, "output", "o", "-", "output file of pull and manifest schema, '-' for stdout")
 
 	// other flags
 	flag.BoolVar(&showVersion, "version", false, "Prints version info")
@@ -89,20 +181,61 @@ func main() {
 	bindEnv(flag.Lookup("read-timeout"), "PDD_READ_TIMEOUT")
 	bindEnv(flag.Lookup("max-retry"), "PDD_MAX_RETRY")
 
+	// target database variables
+	bindEnv(flag.Lookup("target-addr"), "PDD_TARGET_ADDR")
+	bindEnv(flag.Lookup("target-database"), "PDD_TARGET_DATABASE")
+	bindEnv(flag.Lookup("target-user"), "PDD_TARGET_USER")
+	bindEnv(flag.Lookup("target-pass"), "PDD_TARGET_PASS")
+
 	// dump variables
 	bindEnv(flag.Lookup("manifest-file"), "PDD_MANIFEST_FILE")
+	bindEnv(flag.Lookup("format"), "PDD_FORMAT")
+	bindVarsEnv(dc.Vars, "PDD_VAR_")
+	bindEnv(flag.Lookup("deterministic"), "PDD_DETERMINISTIC")
+	bindEnv(flag.Lookup("truncate"), "PDD_TRUNCATE")
+	bindEnv(flag.Lookup("rows-per-insert"), "PDD_ROWS_PER_INSERT")
+	bindEnv(flag.Lookup("column-inserts"), "PDD_COLUMN_INSERTS")
+	bindEnv(flag.Lookup("on-conflict-do-nothing"), "PDD_ON_CONFLICT_DO_NOTHING")
+	bindEnv(flag.Lookup("parquet-row-group-size"), "PDD_PARQUET_ROW_GROUP_SIZE")
+	bindEnv(flag.Lookup("parquet-compression"), "PDD_PARQUET_COMPRESSION")
+	bindEnv(flag.Lookup("tar-compression"), "PDD_TAR_COMPRESSION")
+	bindEnv(flag.Lookup("spool-dir"), "PDD_SPOOL_DIR")
+
+	// storage variables
+	bindEnv(flag.Lookup("storage-policy"), "PDD_STORAGE_POLICY")
+	bindEnv(flag.Lookup("max-object-size"), "PDD_MAX_OBJECT_SIZE")
+
+	// timeout variables
+	bindEnv(flag.Lookup("timeout"), "PDD_TIMEOUT")
+	bindEnv(flag.Lookup("idle-timeout"), "PDD_IDLE_TIMEOUT")
 
 	// backend variables
 	bindEnv(flag.Lookup("backend"), "PDD_BACKEND")
 
 	// backend - fs
 	bindEnv(flag.Lookup("filesystem-root"), "PDD_FILESYSTEM_ROOT")
+	bindEnv(flag.Lookup("filesystem-file-mode"), "PDD_FILESYSTEM_FILE_MODE")
+	bindEnv(flag.Lookup("filesystem-dir-mode"), "PDD_FILESYSTEM_DIR_MODE")
+
+	// backend - oci
+	bindEnv(flag.Lookup("oci-repository"), "PDD_OCI_REPOSITORY")
+	bindEnv(flag.Lookup("oci-tag"), "PDD_OCI_TAG")
+	bindEnv(flag.Lookup("oci-media-type"), "PDD_OCI_MEDIA_TYPE")
+	bindEnv(flag.Lookup("oci-username"), "PDD_OCI_USERNAME")
+	bindEnv(flag.Lookup("oci-password"), "PDD_OCI_PASSWORD")
+	bindEnv(flag.Lookup("oci-plain-http"), "PDD_OCI_PLAIN_HTTP")
 
 	// Parse Options
 	if err := flag.Parse(os.Args); err != nil {
 		syslog.Fatalf("%#v", err)
 	}
 
+	for _, root := range fsRoots {
+		c := fsc
+		c.Root = root
+		bc.FileSystem = append(bc.FileSystem, c)
+	}
+
 	// print application version
 	if showVersion {
 		syslog.Printf("Version    : %s\n", version)
@@ -123,6 +256,127 @@ func main() {
 
 	logger.Debug("version", version, "git commit", commit, "build date", date)
 
+	// resolve command, dump is the default one
+	command, args := commandDump, flag.Args()[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case commandDump:
+		// deterministic dumps need the same text output of values regardless of the server configuration
+		dbc.NormalizeSession = dc.Deterministic
+
+		if err := checkOCI(bc, dc.Format, maxObjectSize); err != nil {
+			logger.Error("msg", "invalid oci backend configuration", "error", err)
+			os.Exit(1)
+		}
+
+		dumpCmd(logger, dbc, dc, wc, newStorageOrExit(logger, sc, bc), maxObjectSize)
+	case commandPull:
+		if len(args) != 1 {
+			logger.Error("msg", "pull requires exactly one key", "args", strings.Join(args, " "))
+			os.Exit(1)
+		}
+
+		if err := pull(logger, wc, newStorageOrExit(logger, sc, bc), args[0], output); err != nil {
+			logger.Error("msg", "failed to pull dump", "key", args[0], "error", err)
+			os.Exit(1)
+		}
+	case commandCopy:
+		// target shares connection options with the source
+		tdbc.MaxRetries, tdbc.DialTimeout, tdbc.ReadTimeout = dbc.MaxRetries, dbc.DialTimeout, dbc.ReadTimeout
+
+		copyCmd(logger, dbc, tdbc, dc, wc)
+	case commandRestore:
+		if len(args) != 1 {
+			logger.Error("msg", "restore requires exactly one dump name", "args", strings.Join(args, " "))
+			os.Exit(1)
+		}
+
+		// target shares connection options with the source
+		tdbc.MaxRetries, tdbc.DialTimeout, tdbc.ReadTimeout = dbc.MaxRetries, dbc.DialTimeout, dbc.ReadTimeout
+
+		restoreCmd(logger, tdbc, dc, wc, newStorageOrExit(logger, sc, bc), args[0])
+	case commandManifest:
+		if len(args) != 1 || args[0] != "schema" {
+			logger.Error("msg", "manifest requires the schema subcommand", "args", strings.Join(args, " "))
+			os.Exit(1)
+		}
+
+		if err := manifestSchema(logger, output); err != nil {
+			logger.Error("msg", "failed to write manifest schema", "error", err)
+			os.Exit(1)
+		}
+	case commandInit:
+		if err := initCmd(logger, dbc, dc.ManifestFile); err != nil {
+			logger.Error("msg", "failed to generate manifest", "file", dc.ManifestFile, "error", err)
+			os.Exit(1)
+		}
+	default:
+		logger.Error("msg", "unknown command", "command", command)
+		os.Exit(1)
+	}
+}
+
+// newStorageOrExit creates storage or exits on failure.
+func newStorageOrExit(logger log.Logger, sc storage.Config, bc backend.Config) storage.Storage {
+	s, err := newStorage(logger, sc, bc)
+	if err != nil {
+		logger.Error("msg", "failed to create storage", "error", err)
+		os.Exit(1)
+	}
+
+	return s
+}
+
+// newStorage creates storage writing to all configured backends.
+func newStorage(logger log.Logger, sc storage.Config, bc backend.Config) (storage.Storage, error) {
+	destinations := make([]storage.Destination, 0, len(bc.Types))
+
+	for i, typ := range bc.Types {
+		for _, prev := range bc.Types[:i] {
+			if prev == typ {
+				return nil, errors.Errorf("backend %s given more than once", typ)
+			}
+		}
+
+		backends, err := backend.FromConfig(logger, typ, bc)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range backends {
+			destinations = append(destinations, storage.Destination{Name: b.Name, Backend: b.Backend})
+		}
+	}
+
+	return storage.New(logger, sc, destinations...)
+}
+
+// checkOCI checks the dump is stored as a single object when the oci backend is used. Every object is pushed as an
+// artifact tagged by the tag template, so the objects of a dump would overwrite each other.
+func checkOCI(bc backend.Config, format string, maxSize int64) error {
+	for _, typ := range bc.Types {
+		if typ != backend.OCI {
+			continue
+		}
+
+		if !dump.SingleObject(format) {
+			return errors.Errorf("oci backend supports only dumps of a single object, %s format writes a file per table",
+				format)
+		}
+
+		if maxSize > 0 {
+			return errors.New("oci backend supports only dumps of a single object, max-object-size can't be used")
+		}
+	}
+
+	return nil
+}
+
+// dumpCmd dumps the database into the storage.
+func dumpCmd(logger log.Logger, dbc database.Config, dc dump.Config, wc watchdog.Config, s storage.Storage, maxSize int64) {
 	// initialize db
 	db, err := database.ConnectDB(logger, &dbc)
 	if err != nil {
@@ -137,45 +391,203 @@ func main() {
 		os.Exit(1)
 	}
 
-	// initialize backend
-	b, err := backend.FromConfig(logger, bc)
+	if err := run(logger, wc, dumper, s, generateName(), maxSize); err != nil {
+		if err == storage.ErrPartialFailure {
+			logger.Warn("msg", "dump is not written to all destinations", "error", err)
+			os.Exit(exitPartialFailure)
+		}
+
+		logger.Error("msg", "failed to dump database", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Debug("msg", "export finished")
+}
+
+// copyCmd copies the database into the target database.
+func copyCmd(logger log.Logger, dbc, tdbc database.Config, dc dump.Config, wc watchdog.Config) {
+	source, err := database.ConnectDB(logger.With("database", "source"), &dbc)
 	if err != nil {
-		logger.Error("msg", "failed to create backend", "error", err)
+		logger.Error("msg", "failed to create source database", "error", err)
 		os.Exit(1)
 	}
 
-	// initialize storage
-	s := storage.New(logger, b, storage.DefaultOperationTimeout)
+	target, err := database.ConnectDB(logger.With("database", "target"), &tdbc)
+	if err != nil {
+		logger.Error("msg", "failed to create target database", "error", err)
+		os.Exit(1)
+	}
 
-	if err := run(logger, dumper, s); err != nil {
-		logger.Error("msg", "failed to dump database", "error", err)
+	copier, err := dump.NewCopier(logger, source, target, dc)
+	if err != nil {
+		logger.Error("msg", "failed to create copier", "error", err)
 		os.Exit(1)
 	}
 
-	logger.Debug("msg", "export finished")
+	ctx, wd, cancel := watchdog.New(context.Background(), wc)
+	err = wd.Err(copier.Copy(ctx, wd.Writer))
+
+	cancel()
+
+	if err != nil {
+		logger.Error("msg", "failed to copy database", "error", err)
+		os.Exit(1)
+	}
+
+	logger.Debug("msg", "copy finished")
+}
+
+// restoreCmd restores the dump stored under name into the target database.
+func restoreCmd(logger log.Logger, tdbc database.Config, dc dump.Config, wc watchdog.Config, s storage.Storage, name string) {
+	target, err := database.ConnectDB(logger.With("database", "target"), &tdbc)
+	if err != nil {
+		logger.Error("msg", "failed to create target database", "error", err)
+		os.Exit(1)
+	}
+
+	restorer := dump.NewRestorer(logger, target, dc)
+
+	ctx, wd, cancel := watchdog.New(context.Background(), wc)
+	err = wd.Err(restorer.Restore(ctx, &storageInput{ctx: ctx, s: s, name: name}, wd.Reader))
+
+	cancel()
+
+	if err != nil {
+		logger.Error("msg", "failed to restore dump", "name", name, "error", err)
+		os.Exit(1)
+	}
+
+	logger.Debug("msg", "restore finished", "name", name)
+}
+
+func run(logger log.Logger, wc watchdog.Config, dumper dump.Dumper, s storage.Storage, name string, maxSize int64) error {
+	// both database copy and upload are bound to the same timeouts.
+	ctx, wd, cancel := watchdog.New(context.Background(), wc)
+	defer cancel()
+
+	out := newStorageOutput(ctx, logger, s, wd, name, maxSize)
+
+	if err := dumper.Dump(ctx, out); err != nil {
+		return wd.Err(err)
+	}
+
+	if out.isPartial() {
+		return storage.ErrPartialFailure
+	}
+
+	return nil
+}
+
+// pull writes contents of the object at the given key to the output file, or stdout. Split objects are reassembled
+// from their parts.
+func pull(logger log.Logger, wc watchdog.Config, s storage.Storage, key, output string) error {
+	ctx, wd, cancel := watchdog.New(context.Background(), wc)
+	defer cancel()
+
+	rc, err := openObject(ctx, s, key)
+	if err != nil {
+		return err
+	}
+
+	defer helpers.CloseWithErrLogf(logger, rc, "pull, close reader")
+
+	w := io.Writer(os.Stdout)
+
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return errors.Wrapf(err, "can't create output file %s", output)
+		}
+
+		defer helpers.CloseWithErrLogf(logger, f, "pull, close output")
+
+		w = f
+	}
+
+	if _, err := io.Copy(wd.Writer(w), rc); err != nil {
+		return wd.Err(err)
+	}
+
+	logger.Debug("msg", "pull finished", "key", key)
+
+	return nil
 }
 
-func run(logger log.Logger, dumper dump.Dumper, s storage.Storage) error {
-	// create a synchronous in-memory pipe.
-	pr, pw := io.Pipe()
+// manifestSchema writes the JSON Schema of manifest files to the output file, or stdout.
+func manifestSchema(logger log.Logger, output string) error {
+	schema, err := dump.ManifestSchema()
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
 
-	defer helpers.CloseWithErrLogf(logger, pr, "dump error")
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return errors.Wrapf(err, "can't create output file %s", output)
+		}
 
-	go func() {
-		defer helpers.CloseWithErrLogf(logger, pw, "dump error")
+		defer helpers.CloseWithErrLogf(logger, f, "manifest schema, close output")
+
+		w = f
+	}
 
-		if err := dumper.Dump(pw); err != nil {
-			logger.Error("error", err)
+	_, err = fmt.Fprintf(w, "%s\n", schema)
+
+	return err
+}
+
+// initCmd writes a manifest generated from the database to the manifest file, an existing file is never overwritten.
+func initCmd(logger log.Logger, dbc database.Config, file string) error {
+	db, err := database.ConnectDB(logger, &dbc)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "can't create manifest file %s", file)
+	}
+
+	if err := dump.GenerateManifest(logger, db, f); err != nil {
+		helpers.CloseWithErrLogf(logger, f, "init, close manifest")
+
+		// leave no partial manifest behind, so init can be run again
+		if err := os.Remove(file); err != nil {
+			logger.Warn("msg", "failed to remove manifest file", "file", file, "error", err)
 		}
-	}()
 
-	return s.Put(generateFileName(), pr)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "can't close manifest file %s", file)
+	}
+
+	logger.Info("msg", "manifest generated", "file", file)
+
+	return nil
 }
 
-// generateFileName generates new file name for dump based on timestamp.
-func generateFileName() string {
+// generateName generates new name for dump based on timestamp.
+func generateName() string {
 	t := time.Now()
-	return fmt.Sprintf("dump-%s.sql", t.Format("20060102-150405"))
+	return fmt.Sprintf("dump-%s", t.Format("20060102-150405"))
+}
+
+// bindVarsEnv sets variables from the environment variables with the given prefix, the rest of the name in lower case
+// is the variable name. Variables given by flags override them.
+func bindVarsEnv(vars map[string]string, prefix string) {
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, prefix) {
+			continue
+		}
+
+		if p := strings.SplitN(strings.TrimPrefix(kv, prefix), "=", 2); len(p) == 2 && p[0] != "" {
+			vars[strings.ToLower(p[0])] = p[1]
+		}
+	}
 }
 
 func bindEnv(fn *pflag.Flag, env string) {
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/aweris/postgres-data-dump/dump"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/aweris/postgres-data-dump/internal/watchdog"
+	"github.com/aweris/postgres-data-dump/storage"
+)
+
+// storageOutput is a dump.Output uploading objects to the storage while they are written.
+type storageOutput struct {
+	ctx    context.Context
+	logger log.Logger
+	s      storage.Storage
+	wd     *watchdog.Watchdog
+	name   string
+	// maxSize splits objects into parts of at most maxSize bytes, if it's not zero
+	maxSize int64
+
+	mu      sync.Mutex
+	partial bool
+}
+
+var _ dump.Output = (*storageOutput)(nil)
+
+func newStorageOutput(
+	ctx context.Context, logger log.Logger, s storage.Storage, wd *watchdog.Watchdog, name string, maxSize int64,
+) *storageOutput {
+	return &storageOutput{ctx: ctx, logger: logger, s: s, wd: wd, name: name, maxSize: maxSize}
+}
+
+// Create starts uploading the object with the given suffix.
+func (o *storageOutput) Create(suffix string) (io.WriteCloser, error) {
+	if o.maxSize > 0 {
+		return newSplitWriter(o, o.name+suffix, o.maxSize), nil
+	}
+
+	return o.create(o.name + suffix), nil
+}
+
+// create starts uploading the object with the given key.
+func (o *storageOutput) create(key string) *objectWriter {
+	// create a synchronous in-memory pipe.
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+
+	go func() {
+		err := o.s.Put(o.ctx, key, pr)
+
+		// unblock the writer in case storage returned without consuming the reader
+		_ = pr.CloseWithError(err)
+
+		done <- err
+	}()
+
+	o.logger.Debug("msg", "create object", "key", key)
+
+	// every byte written to the pipe is consumed by the upload, so writes are the progress of both sides.
+	return &objectWriter{o: o, w: o.wd.Writer(pw), pw: pw, done: done}
+}
+
+// isPartial returns true if any of the objects is not written to all storage destinations.
+func (o *storageOutput) isPartial() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.partial
+}
+
+// objectWriter writes an object to the storage.
+type objectWriter struct {
+	o    *storageOutput
+	w    io.Writer
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Close completes the object and waits until it's stored.
+func (w *objectWriter) Close() error {
+	_ = w.pw.Close()
+
+	err := <-w.done
+	if err == storage.ErrPartialFailure {
+		w.o.mu.Lock()
+		w.o.partial = true
+		w.o.mu.Unlock()
+
+		return nil
+	}
+
+	return err
+}
+
+// CloseWithError aborts the object, so it's not stored.
+func (w *objectWriter) CloseWithError(err error) error {
+	_ = w.pw.CloseWithError(err)
+
+	return <-w.done
+}
+
+// storageInput is a dump.Input reading objects of a dump from the storage.
+type storageInput struct {
+	ctx  context.Context
+	s    storage.Storage
+	name string
+}
+
+var _ dump.Input = (*storageInput)(nil)
+
+// Open opens the object with the given suffix.
+func (i *storageInput) Open(suffix string) (io.ReadCloser, error) {
+	return openObject(i.ctx, i.s, i.name+suffix)
+}
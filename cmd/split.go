@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/aweris/postgres-data-dump/dump"
+	"github.com/aweris/postgres-data-dump/storage"
+	"github.com/pkg/errors"
+)
+
+// partIndex lists the parts of an object split into size bounded parts, in order.
+type partIndex struct {
+	Key    string      `json:"key"`
+	Size   int64       `json:"size"`
+	SHA256 string      `json:"sha256"`
+	Parts  []partEntry `json:"parts"`
+}
+
+// partEntry describes a part of a split object.
+type partEntry struct {
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// partKey returns the key of the nth part of the object, e.g. dump-20261018-120000.part-0001.sql.
+func partKey(key string, n int) string {
+	ext := path.Ext(key)
+
+	return fmt.Sprintf("%s.part-%04d%s", strings.TrimSuffix(key, ext), n, ext)
+}
+
+// indexKey returns the key of the index of the object, e.g. dump-20261018-120000.index.json.
+func indexKey(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ".index.json"
+}
+
+// splitWriter writes an object as parts of at most max bytes, followed by an index listing them. Parts end between
+// writes, or at a boundary marked by the dumper when the part is at least half full. Writes larger than a part are
+// split at a line end when possible.
+type splitWriter struct {
+	o   *storageOutput
+	key string
+	max int64
+
+	part     *objectWriter
+	partSize int64
+	partHash hash.Hash
+	hash     hash.Hash
+	index    partIndex
+
+	// err is the failure of a part closed at a boundary
+	err error
+}
+
+var _ dump.Boundary = (*splitWriter)(nil)
+
+func newSplitWriter(o *storageOutput, key string, max int64) *splitWriter {
+	return &splitWriter{o: o, key: key, max: max, hash: sha256.New(), index: partIndex{Key: key}}
+}
+
+func (w *splitWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+
+	for len(p) > 0 {
+		// the write doesn't fit in the rest of the part, it starts the next one
+		if w.part != nil && w.partSize > 0 && w.partSize+int64(len(p)) > w.max {
+			if err := w.closePart(); err != nil {
+				return written, err
+			}
+		}
+
+		if w.part == nil {
+			w.openPart()
+		}
+
+		n := len(p)
+
+		// the write is larger than a part
+		if int64(n) > w.max {
+			n = int(w.max)
+
+			if i := bytes.LastIndexByte(p[:n], '\n'); i >= 0 {
+				n = i + 1
+			}
+		}
+
+		if _, err := w.part.Write(p[:n]); err != nil {
+			return written, err
+		}
+
+		w.partSize += int64(n)
+		w.partHash.Write(p[:n])
+		w.hash.Write(p[:n])
+
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Boundary ends the current part if it's at least half full, so the next one starts at the boundary.
+func (w *splitWriter) Boundary() {
+	if w.err == nil && w.part != nil && w.partSize >= w.max/2 {
+		// reported by the next write or close
+		w.err = w.closePart()
+	}
+}
+
+// Close stores the last part and the index.
+func (w *splitWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	// empty objects have a single empty part
+	if w.part == nil && len(w.index.Parts) == 0 {
+		w.openPart()
+	}
+
+	if w.part != nil {
+		if err := w.closePart(); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range w.index.Parts {
+		w.index.Size += p.Size
+	}
+
+	w.index.SHA256 = hex.EncodeToString(w.hash.Sum(nil))
+
+	iw := w.o.create(indexKey(w.key))
+
+	enc := json.NewEncoder(iw)
+	enc.SetIndent("", "  ")
+
+	if err := enc.Encode(w.index); err != nil {
+		return iw.CloseWithError(err)
+	}
+
+	return iw.Close()
+}
+
+// CloseWithError aborts the current part, the index is not written, so the object is incomplete.
+func (w *splitWriter) CloseWithError(err error) error {
+	if w.part == nil {
+		return nil
+	}
+
+	return w.part.CloseWithError(err)
+}
+
+func (w *splitWriter) openPart() {
+	key := partKey(w.key, len(w.index.Parts)+1)
+
+	w.part, w.partSize, w.partHash = w.o.create(key), 0, sha256.New()
+	w.index.Parts = append(w.index.Parts, partEntry{Key: key})
+}
+
+func (w *splitWriter) closePart() error {
+	p := &w.index.Parts[len(w.index.Parts)-1]
+	p.Size, p.SHA256 = w.partSize, hex.EncodeToString(w.partHash.Sum(nil))
+
+	part := w.part
+	w.part = nil
+
+	return part.Close()
+}
+
+// openObject opens the object at the given key. Objects missing at the key are looked up as split objects, their
+// parts are read in order and verified against the index.
+func openObject(ctx context.Context, s storage.Storage, key string) (io.ReadCloser, error) {
+	rc, err := s.Get(ctx, key)
+	if err == nil {
+		return rc, nil
+	}
+
+	irc, ierr := s.Get(ctx, indexKey(key))
+	if ierr != nil {
+		return nil, err
+	}
+
+	defer irc.Close()
+
+	var index partIndex
+	if err := json.NewDecoder(irc).Decode(&index); err != nil {
+		return nil, errors.Wrapf(err, "failed to read index of %s", key)
+	}
+
+	return &partsReader{ctx: ctx, s: s, index: index, hash: sha256.New()}, nil
+}
+
+// partsReader reads parts of a split object in order, verifying sizes and checksums of each part and the whole object.
+type partsReader struct {
+	ctx   context.Context
+	s     storage.Storage
+	index partIndex
+	hash  hash.Hash
+
+	next     int
+	part     io.ReadCloser
+	partSize int64
+	partHash hash.Hash
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.part == nil {
+			if r.next == len(r.index.Parts) {
+				if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != r.index.SHA256 {
+					return 0, errors.Errorf("checksum mismatch of %s", r.index.Key)
+				}
+
+				return 0, io.EOF
+			}
+
+			rc, err := r.s.Get(r.ctx, r.index.Parts[r.next].Key)
+			if err != nil {
+				return 0, err
+			}
+
+			r.part, r.partSize, r.partHash = rc, 0, sha256.New()
+		}
+
+		n, err := r.part.Read(p)
+
+		r.partSize += int64(n)
+		r.partHash.Write(p[:n])
+		r.hash.Write(p[:n])
+
+		if err == io.EOF {
+			if err := r.closePart(); err != nil {
+				return n, err
+			}
+
+			if n == 0 {
+				continue
+			}
+
+			return n, nil
+		}
+
+		return n, err
+	}
+}
+
+// closePart closes the current part and verifies it.
+func (r *partsReader) closePart() error {
+	entry := r.index.Parts[r.next]
+
+	_ = r.part.Close()
+	r.part = nil
+	r.next++
+
+	if r.partSize != entry.Size || hex.EncodeToString(r.partHash.Sum(nil)) != entry.SHA256 {
+		return errors.Errorf("part %s is corrupted", entry.Key)
+	}
+
+	return nil
+}
+
+func (r *partsReader) Close() error {
+	if r.part == nil {
+		return nil
+	}
+
+	return r.part.Close()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/aweris/postgres-data-dump/internal/watchdog"
+)
+
+// memStorage is a storage keeping the objects in memory.
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memStorage) Put(_ context.Context, p string, r io.Reader) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.objects[p] = data
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *memStorage) Get(_ context.Context, p string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, ok := s.objects[p]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func newTestOutput(t *testing.T, s *memStorage, maxSize int64) *storageOutput {
+	logger, err := log.NewLogger(log.LevelInfo, log.FormatFmt, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, wd, cancel := watchdog.New(context.Background(), watchdog.Config{})
+	t.Cleanup(cancel)
+
+	return newStorageOutput(ctx, logger, s, wd, "dump-20261018-120000", maxSize)
+}
+
+func TestPartKey(t *testing.T) {
+	tests := map[string]string{
+		"dump-20261018-120000.sql":           "dump-20261018-120000.part-0001.sql",
+		"dump-20261018-120000/users.csv":     "dump-20261018-120000/users.part-0001.csv",
+		"dump-20261018-120000":               "dump-20261018-120000.part-0001",
+		"dump-20261018-120000.tar.gz":        "dump-20261018-120000.tar.part-0001.gz",
+		"dump-20261018-120000/manifest.json": "dump-20261018-120000/manifest.part-0001.json",
+	}
+
+	for key, want := range tests {
+		if got := partKey(key, 1); got != want {
+			t.Errorf("partKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// writeSplit writes the chunks to a split object, boundaries are marked by empty chunks.
+func writeSplit(t *testing.T, s *memStorage, max int64, chunks ...string) {
+	o := newTestOutput(t, s, max)
+
+	w, err := o.Create(".sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range chunks {
+		if c == "" {
+			w.(*splitWriter).Boundary()
+
+			continue
+		}
+
+		if n, err := w.Write([]byte(c)); err != nil || n != len(c) {
+			t.Fatalf("write %q: %d, %v", c, n, err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitWriter(t *testing.T) {
+	s := &memStorage{objects: make(map[string][]byte)}
+
+	// writes fit into parts of 10 bytes, parts end at boundaries when they're half full, larger writes are split at
+	// line ends
+	writeSplit(t, s, 10, "abcd\n", "ef\n", "", "ghijkl\n", "", "mn\n", "opqrstuv\nwxyz0123456789\n")
+
+	want := map[string]string{
+		"dump-20261018-120000.part-0001.sql": "abcd\nef\n",
+		"dump-20261018-120000.part-0002.sql": "ghijkl\n",
+		"dump-20261018-120000.part-0003.sql": "mn\n",
+		"dump-20261018-120000.part-0004.sql": "opqrstuv\n",
+		"dump-20261018-120000.part-0005.sql": "wxyz012345",
+		"dump-20261018-120000.part-0006.sql": "6789\n",
+	}
+
+	for key, data := range want {
+		if got := string(s.objects[key]); got != data {
+			t.Errorf("unexpected part %s %q, want %q", key, got, data)
+		}
+	}
+
+	var index partIndex
+	if err := json.Unmarshal(s.objects["dump-20261018-120000.index.json"], &index); err != nil {
+		t.Fatal(err)
+	}
+
+	content := "abcd\nef\nghijkl\nmn\nopqrstuv\nwxyz0123456789\n"
+
+	if len(index.Parts) != len(want) || index.Size != int64(len(content)) || index.Key != "dump-20261018-120000.sql" {
+		t.Fatalf("unexpected index %+v", index)
+	}
+
+	if len(s.objects) != len(want)+1 {
+		t.Fatalf("unexpected objects %d", len(s.objects))
+	}
+
+	// parts are read back in order
+	rc, err := openObject(context.Background(), s, "dump-20261018-120000.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadAll(rc)
+	if err != nil || string(got) != content {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	if err := rc.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitWriterEmpty(t *testing.T) {
+	s := &memStorage{objects: make(map[string][]byte)}
+
+	writeSplit(t, s, 10)
+
+	data, ok := s.objects["dump-20261018-120000.part-0001.sql"]
+	if !ok || len(data) != 0 {
+		t.Fatalf("unexpected objects %v", s.objects)
+	}
+
+	rc, err := openObject(context.Background(), s, "dump-20261018-120000.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := ioutil.ReadAll(rc); err != nil || len(got) != 0 {
+		t.Fatalf("read %q, %v", got, err)
+	}
+}
+
+func TestPartsReaderCorrupted(t *testing.T) {
+	tests := map[string]func(s *memStorage){
+		"part dump-20261018-120000.part-0002.sql is corrupted": func(s *memStorage) {
+			s.objects["dump-20261018-120000.part-0002.sql"] = []byte("GHIJKL\n")
+		},
+		"part dump-20261018-120000.part-0001.sql is corrupted": func(s *memStorage) {
+			s.objects["dump-20261018-120000.part-0001.sql"] = []byte("abcd\n")
+		},
+		"checksum mismatch": func(s *memStorage) {
+			var index partIndex
+			_ = json.Unmarshal(s.objects["dump-20261018-120000.index.json"], &index)
+
+			index.SHA256 = strings.Repeat("0", 64)
+			s.objects["dump-20261018-120000.index.json"], _ = json.Marshal(index)
+		},
+		"file does not exist": func(s *memStorage) {
+			delete(s.objects, "dump-20261018-120000.part-0002.sql")
+		},
+	}
+
+	for msg, corrupt := range tests {
+		s := &memStorage{objects: make(map[string][]byte)}
+		writeSplit(t, s, 10, "abcd\nef\n", "ghijkl\n")
+
+		corrupt(s)
+
+		rc, err := openObject(context.Background(), s, "dump-20261018-120000.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := ioutil.ReadAll(rc); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %s, got %v", msg, err)
+		}
+	}
+
+	// objects missing without an index are reported as missing
+	s := &memStorage{objects: make(map[string][]byte)}
+	if _, err := openObject(context.Background(), s, "dump.sql"); !reflect.DeepEqual(err, os.ErrNotExist) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
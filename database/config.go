@@ -22,4 +22,7 @@ type Config struct {
 	MaxRetries  int
 	DialTimeout time.Duration
 	ReadTimeout time.Duration
+
+	// NormalizeSession sets session settings affecting the text output of values to fixed values on each connection.
+	NormalizeSession bool
 }
@@ -2,46 +2,181 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
 
 	"github.com/aweris/postgres-data-dump/internal/log"
 	"github.com/go-pg/pg/extra/pgotel"
 	"github.com/go-pg/pg/v10"
+	"github.com/go-pg/pg/v10/orm"
 	"github.com/pkg/errors"
 )
 
+// Info contains information about the connected database.
+type Info struct {
+	Name          string
+	ServerVersion string
+}
+
+// Column contains name and type of a table column.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Table contains a table of the database with its estimated row count.
+type Table struct {
+	Name string
+	// EstimatedRows is the row count estimated by the last analyze, -1 if the table isn't analyzed yet
+	EstimatedRows int64
+	Partitioned   bool
+}
+
+// Sequence contains a sequence owned by a table column, by a serial or identity column.
+type Sequence struct {
+	Name   string
+	Column string
+}
+
+// ColumnDefinition contains the definition of a table column.
+type ColumnDefinition struct {
+	Name    string
+	Type    string
+	NotNull bool
+	// Default is the default expression, or the generation expression of generated columns
+	Default string
+	// Identity is 'a' for GENERATED ALWAYS and 'd' for GENERATED BY DEFAULT identity columns
+	Identity string
+	// Generated is 's' for stored generated columns
+	Generated string
+}
+
+// Constraint contains a primary key, unique, check or foreign key constraint of a table.
+type Constraint struct {
+	Name string
+	// Type is the constraint type, 'p' for primary keys, 'u' for unique constraints, 'c' for check constraints and 'f'
+	// for foreign keys
+	Type       string
+	Definition string
+	Columns    []string `pg:",array"`
+	RefTable   string
+	RefColumns []string `pg:",array"`
+}
+
 // DB wrapper interface for the postgres database.
 type DB interface {
+	// GetInfo returns name and server version of the database
+	GetInfo() (*Info, error)
+
+	// GetTables returns the tables of the database outside the system schemas, except partitions and extension tables
+	GetTables() ([]Table, error)
+
 	// GetTableColumns returns column names for the given table
 	GetTableColumns(table string) ([]string, error)
 
+	// GetTableColumnTypes returns columns with their types for the given table
+	GetTableColumnTypes(table string) ([]Column, error)
+
 	// GetTableDependencies returns  dependent tables for the given table
 	GetTableDependencies(table string) ([]string, error)
 
-	// CopyTo copy data from a table to io.Writer
-	CopyTo(w io.Writer, table string) error
+	// GetTableDefinition returns column definitions of the given table
+	GetTableDefinition(table string) ([]ColumnDefinition, error)
+
+	// GetTableSequences returns the sequences owned by columns of the given table
+	GetTableSequences(table string) ([]Sequence, error)
+
+	// GetTableConstraints returns primary key, unique, check and foreign key constraints of the given table
+	GetTableConstraints(table string) ([]Constraint, error)
+
+	// CopyTo copy data from a table to io.Writer using given COPY options. Copy is cancelled when context is done.
+	CopyTo(ctx context.Context, w io.Writer, table string, options ...string) error
+
+	// GetQueryValues returns the values of the first column of the rows returned by the query, decoded from JSON
+	GetQueryValues(query string) ([]interface{}, error)
+
+	// Begin starts a transaction bound to the given context.
+	Begin(ctx context.Context) (Tx, error)
+
+	// Snapshot starts a repeatable read transaction bound to the given context, all queries of the returned DB see the
+	// database as of its first query.
+	Snapshot(ctx context.Context) (Snapshot, error)
+}
+
+// Snapshot is a DB running all queries in a single transaction on a single connection.
+type Snapshot interface {
+	DB
+
+	// Exec executes the query in the transaction of the snapshot, e.g. to create temporary tables
+	Exec(query string) error
+
+	// Close ends the transaction of the snapshot
+	Close() error
+}
+
+// Tx wrapper interface for a postgres transaction.
+type Tx interface {
+	// Exec executes the query in the transaction
+	Exec(query string) error
+
+	// CopyFrom copy data from io.Reader to a table using given COPY FROM STDIN statement
+	CopyFrom(r io.Reader, query string) error
+
+	// Commit commits the transaction
+	Commit() error
+
+	// Rollback rolls back the transaction
+	Rollback() error
+}
+
+// normalizedSettings are session settings making the text output of values independent of the server and user
+// configuration.
+var normalizedSettings = []string{
+	"SET client_encoding = 'UTF8'",
+	"SET TimeZone = 'UTC'",
+	"SET DateStyle = 'ISO, YMD'",
+	"SET IntervalStyle = 'postgres'",
+	"SET extra_float_digits = 3",
+	"SET bytea_output = 'hex'",
+	"SET lc_monetary = 'C'",
 }
 
 type db struct {
-	pgdb   *pg.DB
+	pgdb *pg.DB
+	// q runs the queries, the database itself or the transaction of a snapshot
+	q      orm.DB
+	tx     *pg.Tx
 	logger log.Logger
 }
 
 // ConnectDB connects to a database using provided options.
 func ConnectDB(logger log.Logger, cfg *Config) (DB, error) {
-	pgdb := pg.Connect(
-		&pg.Options{
-			Addr:        cfg.Addr,
-			User:        cfg.User,
-			Password:    cfg.Password,
-			Database:    cfg.Database,
-			MaxRetries:  cfg.MaxRetries,
-			DialTimeout: cfg.DialTimeout,
-			ReadTimeout: cfg.ReadTimeout,
-		},
-	)
+	opts := &pg.Options{
+		Addr:        cfg.Addr,
+		User:        cfg.User,
+		Password:    cfg.Password,
+		Database:    cfg.Database,
+		MaxRetries:  cfg.MaxRetries,
+		DialTimeout: cfg.DialTimeout,
+		ReadTimeout: cfg.ReadTimeout,
+	}
+
+	if cfg.NormalizeSession {
+		opts.OnConnect = func(ctx context.Context, cn *pg.Conn) error {
+			for _, setting := range normalizedSettings {
+				if _, err := cn.ExecContext(ctx, setting); err != nil {
+					return errors.Wrap(err, "failed to normalize session settings")
+				}
+			}
+
+			return nil
+		}
+	}
+
+	pgdb := pg.Connect(opts)
 
 	// Enable tracing
 	pgdb.AddQueryHook(pgotel.TracingHook{})
@@ -52,7 +187,62 @@ func ConnectDB(logger log.Logger, cfg *Config) (DB, error) {
 
 	logger.Debug("msg", "connected to the database", "database", cfg.Database, "user", cfg.User)
 
-	return &db{pgdb: pgdb, logger: logger}, nil
+	return &db{pgdb: pgdb, q: pgdb, logger: logger}, nil
+}
+
+func (d *db) GetInfo() (*Info, error) {
+	info := Info{}
+
+	sql := `SELECT current_database() AS name, current_setting('server_version') AS server_version`
+
+	if _, err := d.q.QueryOne(&info, sql); err != nil {
+		d.logger.Error("msg", "failed to get database info", "err", err)
+
+		return nil, errors.Wrap(err, "failed to get database info")
+	}
+
+	d.logger.Debug("msg", "get database info", "name", info.Name, "version", info.ServerVersion)
+
+	return &info, nil
+}
+
+func (d *db) GetTables() ([]Table, error) {
+	var tables []Table
+
+	// relispartition is read through to_jsonb to support versions without partitions
+	sql := `
+		SELECT c.oid::regclass::text AS name,
+		       CASE WHEN c.relkind = 'p'
+		            THEN (SELECT COALESCE(sum(GREATEST(p.reltuples, 0)), 0)
+		                  FROM pg_catalog.pg_inherits i
+		                  JOIN pg_catalog.pg_class p ON p.oid = i.inhrelid
+		                  WHERE i.inhparent = c.oid)
+		            ELSE c.reltuples
+		       END::bigint AS estimated_rows,
+		       c.relkind = 'p' AS partitioned
+		FROM pg_catalog.pg_class c
+		JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+		WHERE c.relkind IN ('r', 'p')
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema')
+		  AND n.nspname NOT LIKE 'pg\_toast%'
+		  AND n.nspname NOT LIKE 'pg\_temp\_%'
+		  AND NOT COALESCE((to_jsonb(c) ->> 'relispartition')::boolean, FALSE)
+		  AND NOT EXISTS (
+		      SELECT 1 FROM pg_catalog.pg_depend d
+		      WHERE d.classid = 'pg_catalog.pg_class'::regclass AND d.objid = c.oid AND d.deptype = 'e'
+		  )
+		ORDER BY 1
+	`
+
+	if _, err := d.q.Query(&tables, sql); err != nil {
+		d.logger.Error("msg", "failed to get tables", "err", err)
+
+		return nil, errors.Wrap(err, "failed to get tables")
+	}
+
+	d.logger.Debug("msg", "get tables", "count", len(tables))
+
+	return tables, nil
 }
 
 func (d *db) GetTableColumns(table string) ([]string, error) {
@@ -67,7 +257,7 @@ func (d *db) GetTableColumns(table string) ([]string, error) {
 		ORDER BY attnum
 	`
 
-	if _, err := d.pgdb.Query(&model, sql, table); err != nil {
+	if _, err := d.q.Query(&model, sql, table); err != nil {
 		d.logger.Error("msg", "failed to get table columns", "table", table, "err", err)
 
 		return nil, errors.Wrap(err, "failed to get table columns")
@@ -83,6 +273,29 @@ func (d *db) GetTableColumns(table string) ([]string, error) {
 	return cols, nil
 }
 
+func (d *db) GetTableColumnTypes(table string) ([]Column, error) {
+	var cols []Column
+
+	sql := `
+		SELECT attname as name, pg_catalog.format_type(atttypid, atttypmod) as type
+		FROM pg_catalog.pg_attribute
+		WHERE attrelid = ?::regclass
+		  AND attnum > 0
+		  AND attisdropped = FALSE
+		ORDER BY attnum
+	`
+
+	if _, err := d.q.Query(&cols, sql, table); err != nil {
+		d.logger.Error("msg", "failed to get table column types", "table", table, "err", err)
+
+		return nil, errors.Wrap(err, "failed to get table column types")
+	}
+
+	d.logger.Debug("msg", "get table column types", "table", table, "count", len(cols))
+
+	return cols, nil
+}
+
 func (d *db) GetTableDependencies(table string) ([]string, error) {
 	var model []struct{ Name string }
 
@@ -91,9 +304,10 @@ func (d *db) GetTableDependencies(table string) ([]string, error) {
 		FROM pg_catalog.pg_constraint
 		WHERE conrelid = ?::regclass
 		  AND contype = 'f'
+		ORDER BY confrelid::regclass::text, conname
 	`
 
-	if _, err := d.pgdb.Query(&model, sql, table); err != nil {
+	if _, err := d.q.Query(&model, sql, table); err != nil {
 		d.logger.Error("msg", "failed to get table dependencies", "table", table, "err", err)
 
 		return nil, errors.Wrap(err, "failed to get table dependencies")
@@ -109,10 +323,230 @@ func (d *db) GetTableDependencies(table string) ([]string, error) {
 	return tables, nil
 }
 
-func (d *db) CopyTo(w io.Writer, table string) error {
-	if _, err := d.pgdb.CopyTo(w, fmt.Sprintf("COPY %s TO STDOUT", table)); err != nil {
+func (d *db) GetTableDefinition(table string) ([]ColumnDefinition, error) {
+	var cols []ColumnDefinition
+
+	// identity and generated columns are read through to_jsonb to support versions without them
+	sql := `
+		SELECT a.attname AS name,
+		       pg_catalog.format_type(a.atttypid, a.atttypmod) AS type,
+		       a.attnotnull AS not_null,
+		       COALESCE(pg_catalog.pg_get_expr(d.adbin, d.adrelid), '') AS "default",
+		       COALESCE(to_jsonb(a) ->> 'attidentity', '') AS identity,
+		       COALESCE(to_jsonb(a) ->> 'attgenerated', '') AS generated
+		FROM pg_catalog.pg_attribute a
+		LEFT JOIN pg_catalog.pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+		WHERE a.attrelid = ?::regclass
+		  AND a.attnum > 0
+		  AND a.attisdropped = FALSE
+		ORDER BY a.attnum
+	`
+
+	if _, err := d.q.Query(&cols, sql, table); err != nil {
+		d.logger.Error("msg", "failed to get table definition", "table", table, "err", err)
+
+		return nil, errors.Wrap(err, "failed to get table definition")
+	}
+
+	d.logger.Debug("msg", "get table definition", "table", table, "count", len(cols))
+
+	return cols, nil
+}
+
+func (d *db) GetTableSequences(table string) ([]Sequence, error) {
+	var sequences []Sequence
+
+	// sequences of serial columns are auto dependencies of their columns, sequences of identity columns are internal
+	sql := `
+		SELECT s.oid::regclass::text AS name,
+		       a.attname AS column
+		FROM pg_catalog.pg_depend d
+		JOIN pg_catalog.pg_class s ON s.oid = d.objid AND s.relkind = 'S'
+		JOIN pg_catalog.pg_attribute a ON a.attrelid = d.refobjid AND a.attnum = d.refobjsubid
+		WHERE d.classid = 'pg_catalog.pg_class'::regclass
+		  AND d.refclassid = 'pg_catalog.pg_class'::regclass
+		  AND d.refobjid = ?::regclass
+		  AND d.deptype IN ('a', 'i')
+		ORDER BY a.attnum
+	`
+
+	if _, err := d.q.Query(&sequences, sql, table); err != nil {
+		d.logger.Error("msg", "failed to get table sequences", "table", table, "err", err)
+
+		return nil, errors.Wrap(err, "failed to get table sequences")
+	}
+
+	d.logger.Debug("msg", "get table sequences", "table", table, "count", len(sequences))
+
+	return sequences, nil
+}
+
+func (d *db) GetTableConstraints(table string) ([]Constraint, error) {
+	var constraints []Constraint
+
+	sql := `
+		SELECT c.conname AS name,
+		       c.contype AS type,
+		       pg_catalog.pg_get_constraintdef(c.oid) AS definition,
+		       ARRAY(
+		           SELECT a.attname
+		           FROM unnest(c.conkey) WITH ORDINALITY AS k(attnum, n)
+		           JOIN pg_catalog.pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+		           ORDER BY k.n
+		       )::text[] AS columns,
+		       CASE WHEN c.contype = 'f' THEN c.confrelid::regclass::text ELSE '' END AS ref_table,
+		       ARRAY(
+		           SELECT a.attname
+		           FROM unnest(c.confkey) WITH ORDINALITY AS k(attnum, n)
+		           JOIN pg_catalog.pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.attnum
+		           ORDER BY k.n
+		       )::text[] AS ref_columns
+		FROM pg_catalog.pg_constraint c
+		WHERE c.conrelid = ?::regclass
+		  AND c.contype IN ('p', 'u', 'c', 'f')
+		ORDER BY c.contype = 'f', c.contype = 'c', c.contype = 'u', c.conname
+	`
+
+	if _, err := d.q.Query(&constraints, sql, table); err != nil {
+		d.logger.Error("msg", "failed to get table constraints", "table", table, "err", err)
+
+		return nil, errors.Wrap(err, "failed to get table constraints")
+	}
+
+	d.logger.Debug("msg", "get table constraints", "table", table, "count", len(constraints))
+
+	return constraints, nil
+}
+
+func (d *db) CopyTo(ctx context.Context, w io.Writer, table string, options ...string) error {
+	sql := fmt.Sprintf("COPY %s TO STDOUT", table)
+	if len(options) > 0 {
+		sql = fmt.Sprintf("%s WITH (%s)", sql, strings.Join(options, ", "))
+	}
+
+	// a snapshot copies in its transaction, which is bound to the context of the snapshot
+	if d.tx != nil {
+		if _, err := d.tx.CopyTo(w, sql); err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	if _, err := d.pgdb.WithContext(ctx).CopyTo(w, sql); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (d *db) GetQueryValues(query string) ([]interface{}, error) {
+	var data string
+
+	sql := fmt.Sprintf(`SELECT COALESCE(json_agg(to_json(q.v)), '[]') FROM (%s) AS q(v)`, query)
+
+	if _, err := d.q.QueryOne(pg.Scan(&data), sql); err != nil {
+		d.logger.Error("msg", "failed to get query values", "err", err)
+
+		return nil, errors.Wrap(err, "failed to get query values")
+	}
+
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+
+	var values []interface{}
+	if err := dec.Decode(&values); err != nil {
+		return nil, errors.Wrap(err, "failed to decode query values")
+	}
+
+	// integers are returned as ints, other numbers as floats
+	for i, v := range values {
+		if n, ok := v.(json.Number); ok {
+			if iv, err := strconv.Atoi(n.String()); err == nil {
+				values[i] = iv
+			} else if fv, err := n.Float64(); err == nil {
+				values[i] = fv
+			}
+		}
+	}
+
+	d.logger.Debug("msg", "get query values", "count", len(values))
+
+	return values, nil
+}
+
+func (d *db) Begin(ctx context.Context) (Tx, error) {
+	pgtx, err := d.pgdb.BeginContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	return &tx{pgtx: pgtx, logger: d.logger}, nil
+}
+
+func (d *db) Snapshot(ctx context.Context) (Snapshot, error) {
+	pgtx, err := d.pgdb.BeginContext(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to begin snapshot")
+	}
+
+	if _, err := pgtx.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ"); err != nil {
+		_ = pgtx.Rollback()
+
+		return nil, errors.Wrap(err, "failed to begin snapshot")
+	}
+
+	d.logger.Debug("msg", "begin snapshot")
+
+	return &snapshot{db: &db{pgdb: d.pgdb, q: pgtx, tx: pgtx, logger: d.logger}}, nil
+}
+
+type snapshot struct {
+	*db
+}
+
+func (s *snapshot) Exec(query string) error {
+	if _, err := s.tx.Exec(query); err != nil {
+		s.logger.Error("msg", "failed to execute query", "query", query, "err", err)
+
+		return errors.Wrap(err, "failed to execute query")
+	}
+
+	return nil
+}
+
+// Close rolls back the transaction, the snapshot doesn't change the database and temporary tables are dropped.
+func (s *snapshot) Close() error {
+	return s.tx.Rollback()
+}
+
+type tx struct {
+	pgtx   *pg.Tx
+	logger log.Logger
+}
+
+func (t *tx) Exec(query string) error {
+	if _, err := t.pgtx.Exec(query); err != nil {
+		t.logger.Error("msg", "failed to execute query", "query", query, "err", err)
+
+		return errors.Wrap(err, "failed to execute query")
+	}
+
+	return nil
+}
+
+func (t *tx) CopyFrom(r io.Reader, query string) error {
+	if _, err := t.pgtx.CopyFrom(r, query); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *tx) Commit() error {
+	return t.pgtx.Commit()
+}
+
+func (t *tx) Rollback() error {
+	return t.pgtx.Rollback()
+}
@@ -0,0 +1,86 @@
+package dump
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// hooks are the rendered before and after statements of the manifest.
+type hooks struct {
+	before []string
+	after  []string
+}
+
+// hooks renders the before and after statements, after variables are queried and selections are materialized.
+func (m *manifest) hooks() (*hooks, error) {
+	before, err := renderActions(m, m.Before)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render before statements")
+	}
+
+	after, err := renderActions(m, m.After)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render after statements")
+	}
+
+	return &hooks{before: before, after: after}, nil
+}
+
+// renderActions renders the statement templates with the manifest variables. Selections can't be used, statements run
+// when the dump is loaded or on the target database, where the temporary tables of selections don't exist.
+func renderActions(m *manifest, actions []string) ([]string, error) {
+	if len(actions) == 0 {
+		return nil, nil
+	}
+
+	rendered := make([]string, 0, len(actions))
+
+	for _, action := range actions {
+		selection := ""
+
+		s, err := renderTemplate(m, action, func(name string) (string, error) {
+			selection = name
+
+			return "", ErrSelectionInStatement
+		})
+		if selection != "" {
+			return nil, errors.Wrapf(ErrSelectionInStatement, "selection %s in statement %q", selection, action)
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to render statement %q", action)
+		}
+
+		rendered = append(rendered, s)
+	}
+
+	return rendered, nil
+}
+
+// writeActions writes the statements to a SQL script.
+func writeActions(w io.Writer, actions []string) error {
+	for _, action := range actions {
+		if _, err := fmt.Fprintf(w, "\n%s;\n", action); err != nil {
+			return errors.Wrapf(err, "failed to write statement %q", action)
+		}
+	}
+
+	return nil
+}
+
+// execActions executes the statements in the transaction.
+func execActions(logger log.Logger, tx database.Tx, actions []string) error {
+	for _, action := range actions {
+		if err := tx.Exec(action); err != nil {
+			logger.Error("msg", "failed to run action", "action", action, "error", err)
+
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package dump
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRenderActions(t *testing.T) {
+	m := &manifest{Vars: map[string]interface{}{"days": 30}, selected: map[string]bool{"active_users": true}}
+
+	actions, err := renderActions(m, []string{"DELETE FROM logs WHERE created < now() - interval '{{ .days }} days'"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"DELETE FROM logs WHERE created < now() - interval '30 days'"}; !reflect.DeepEqual(actions, want) {
+		t.Fatalf("unexpected actions %q, want %q", actions, want)
+	}
+
+	// temporary tables of selections don't exist when the statements run
+	_, err = renderActions(m, []string{`DELETE FROM users WHERE id NOT IN (SELECT id FROM {{ sel "active_users" }})`})
+	if errors.Cause(err) != ErrSelectionInStatement {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// queries can use them
+	if _, err := renderQuery(m, `SELECT * FROM {{ sel "active_users" }}`); err != nil {
+		t.Fatal(err)
+	}
+}
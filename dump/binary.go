@@ -0,0 +1,18 @@
+package dump
+
+import (
+	"context"
+	"io"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// writeBinary writes table data in binary COPY format, it can only be loaded into columns of the same types.
+func (d *dumper) writeBinary(ctx context.Context, w io.Writer, t *table, _ []database.Column) error {
+	source, err := copySource(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	return d.db.CopyTo(ctx, w, source, "FORMAT binary")
+}
@@ -0,0 +1,104 @@
+package dump
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/pkg/errors"
+)
+
+// selectColumns removes the excluded and generated columns from the dumped columns of the table and resolves the
+// select list of the dumped columns, set columns are selected as their values cast to the column types. Excluded
+// columns are loaded with their defaults, so NOT NULL columns without a default can't be excluded. Generated columns
+// can't be loaded, they are computed again on load.
+func (nav *navigator) selectColumns(t *table, defs []database.ColumnDefinition) error {
+	byName := make(map[string]database.ColumnDefinition, len(defs))
+	for _, c := range defs {
+		byName[c.Name] = c
+	}
+
+	excluded := make(map[string]bool, len(t.ExcludeColumns))
+
+	for _, name := range t.ExcludeColumns {
+		c, ok := byName[name]
+		if !ok {
+			return errors.Wrapf(ErrUnknownColumn, "excluded column %q of %s", name, t.TableName)
+		}
+
+		if c.NotNull && c.Default == "" && c.Identity == "" && c.Generated == "" {
+			return errors.Wrapf(ErrRequiredColumn, "column %q of %s is NOT NULL without a default and can't be "+
+				"excluded, set a value for it instead", name, t.TableName)
+		}
+
+		excluded[name] = true
+	}
+
+	columns := make([]string, 0, len(t.Columns))
+
+	for _, name := range t.Columns {
+		if byName[name].Generated != "" {
+			nav.logger.Debug("msg", "skip generated column", "table", t.TableName, "column", name)
+
+			continue
+		}
+
+		if !excluded[name] {
+			columns = append(columns, name)
+		}
+	}
+
+	// the table is copied as is without excluded, set or generated columns
+	if len(columns) == len(t.Columns) && len(t.Set) == 0 {
+		return nil
+	}
+
+	dumped := make(map[string]bool, len(columns))
+	for _, name := range columns {
+		dumped[name] = true
+	}
+
+	// checked in the order of names, so errors are the same between runs
+	names := make([]string, 0, len(t.Set))
+	for name := range t.Set {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	values := make(map[string]string, len(t.Set))
+
+	for _, name := range names {
+		c, ok := byName[name]
+		if !ok {
+			return errors.Wrapf(ErrUnknownColumn, "set column %q of %s", name, t.TableName)
+		}
+
+		if !dumped[name] {
+			return errors.Errorf("set column %q of %s is excluded or not in its columns", name, t.TableName)
+		}
+
+		value, err := literal(t.Set[name])
+		if err != nil {
+			return errors.Wrapf(err, "set column %q of %s", name, t.TableName)
+		}
+
+		values[name] = fmt.Sprintf("CAST(%s AS %s)", value, c.Type)
+	}
+
+	selectList := make([]string, 0, len(columns))
+
+	for _, name := range columns {
+		col := quoteIdent(name)
+
+		if value, ok := values[name]; ok {
+			col = fmt.Sprintf("%s AS %s", value, col)
+		}
+
+		selectList = append(selectList, col)
+	}
+
+	t.Columns, t.selectList = columns, selectList
+
+	return nil
+}
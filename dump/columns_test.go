@@ -0,0 +1,89 @@
+package dump
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/pkg/errors"
+)
+
+func TestSelectColumns(t *testing.T) {
+	defs := []database.ColumnDefinition{
+		{Name: "id", Type: "integer", NotNull: true, Identity: "a"},
+		{Name: "email", Type: "text", NotNull: true},
+		{Name: "password", Type: "text"},
+		{Name: "created", Type: "timestamp with time zone", NotNull: true, Default: "now()"},
+		{Name: "search", Type: "tsvector", Generated: "s", Default: "to_tsvector(email)"},
+	}
+
+	all := []string{"id", "email", "password", "created", "search"}
+
+	tests := []struct {
+		name       string
+		table      table
+		columns    []string
+		selectList []string
+		err        error
+	}{
+		{
+			name:       "generated columns are skipped",
+			table:      table{},
+			columns:    []string{"id", "email", "password", "created"},
+			selectList: []string{`"id"`, `"email"`, `"password"`, `"created"`},
+		},
+		{
+			name: "excluded and set columns",
+			table: table{
+				ExcludeColumns: []string{"password", "created"},
+				Set:            map[string]interface{}{"email": "o'brien@example.com"},
+			},
+			columns:    []string{"id", "email"},
+			selectList: []string{`"id"`, `CAST('o''brien@example.com' AS text) AS "email"`},
+		},
+		{
+			name:  "unknown excluded column",
+			table: table{ExcludeColumns: []string{"missing"}},
+			err:   ErrUnknownColumn,
+		},
+		{
+			name:  "required column without a default",
+			table: table{ExcludeColumns: []string{"email"}},
+			err:   ErrRequiredColumn,
+		},
+		{
+			name:  "unknown set column",
+			table: table{Set: map[string]interface{}{"missing": 1}},
+			err:   ErrUnknownColumn,
+		},
+	}
+
+	nav := &navigator{logger: newTestLogger(t)}
+
+	for _, test := range tests {
+		tbl := test.table
+		tbl.TableName, tbl.Columns = "users", all
+
+		err := nav.selectColumns(&tbl, defs)
+		if errors.Cause(err) != test.err {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+
+			continue
+		}
+
+		if test.err != nil {
+			continue
+		}
+
+		if !reflect.DeepEqual(tbl.Columns, test.columns) || !reflect.DeepEqual(tbl.selectList, test.selectList) {
+			t.Errorf("%s: unexpected columns %q and select list %q", test.name, tbl.Columns, tbl.selectList)
+		}
+	}
+
+	// set columns must be dumped
+	tbl := table{TableName: "users", Columns: all, ExcludeColumns: []string{"password"},
+		Set: map[string]interface{}{"password": "x"}}
+	if err := nav.selectColumns(&tbl, defs); err == nil {
+		t.Error("excluded column set")
+	}
+}
@@ -1,11 +1,90 @@
 package dump
 
+import "github.com/aweris/postgres-data-dump/internal/parquet"
+
+// dump formats.
+const (
+	// FormatPlain is a plain SQL script.
+	FormatPlain = "plain"
+	// FormatCustom is a pg_restore compatible custom archive.
+	FormatCustom = "custom"
+	// FormatCSV is a directory of CSV files, one per table.
+	FormatCSV = "csv"
+	// FormatBinary is a directory of binary COPY files, one per table.
+	FormatBinary = "binary"
+	// FormatJSONL is a directory of JSON Lines files, one per table.
+	FormatJSONL = "jsonl"
+	// FormatParquet is a directory of parquet files, one per table.
+	FormatParquet = "parquet"
+	// FormatSQLite is a SQLite database.
+	FormatSQLite = "sqlite"
+	// FormatInserts is a plain SQL script loading data with INSERT statements.
+	FormatInserts = "inserts"
+	// FormatTar is a single tar bundle containing schema, table data, post actions and the manifest.
+	FormatTar = "tar"
+)
+
+// SingleObject returns true if dumps of the format are written as a single object, false for formats writing a file per
+// table.
+func SingleObject(format string) bool {
+	switch format {
+	case FormatCSV, FormatBinary, FormatJSONL, FormatParquet:
+		return false
+	default:
+		return true
+	}
+}
+
+// tar bundle compressions.
+const (
+	TarCompressionNone = "none"
+	TarCompressionGzip = "gzip"
+	TarCompressionZstd = "zstd"
+)
+
 // default values.
 const (
 	DefaultManifestFile = ".pdd.yaml"
+	DefaultFormat       = FormatPlain
+
+	DefaultRowsPerInsert = 100
+
+	DefaultParquetRowGroupSize = 100000
+	DefaultParquetCompression  = parquet.CompressionSnappy
+
+	DefaultTarCompression = TarCompressionNone
 )
 
 // Config contains export configuration options.
 type Config struct {
 	ManifestFile string
+	Format       string
+
+	// Vars override the variables of the manifest.
+	Vars map[string]string
+
+	// Deterministic orders table rows and fixes the creation time, so dumps of unchanged data are identical.
+	Deterministic bool
+
+	// Truncate truncates target tables before loading them, used only by Copier and Restorer.
+	Truncate bool
+
+	// RowsPerInsert is the number of rows in an INSERT statement.
+	RowsPerInsert int
+	// ColumnInserts writes column lists in INSERT statements even when all columns are dumped.
+	ColumnInserts bool
+	// OnConflictDoNothing adds ON CONFLICT DO NOTHING to INSERT statements.
+	OnConflictDoNothing bool
+
+	// ParquetRowGroupSize is the number of rows in a row group of parquet files.
+	ParquetRowGroupSize int
+	// ParquetCompression is the compression codec of parquet files, one of 'none', 'snappy' or 'zstd'.
+	ParquetCompression string
+
+	// TarCompression is the compression of tar bundles, one of 'none', 'gzip' or 'zstd'.
+	TarCompression string
+
+	// SpoolDir is the directory of temporary files spooling table data of tar bundles and sqlite databases, empty
+	// means the default directory for temporary files.
+	SpoolDir string
 }
@@ -0,0 +1,197 @@
+package dump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// Copier provides functionality to copy database into another database with given manifest configuration.
+type Copier interface {
+	// copies tables into the target database in a single transaction, copied data is written through the writer
+	// returned by progress, e.g. to report it
+	Copy(ctx context.Context, progress func(io.Writer) io.Writer) error
+}
+
+type copier struct {
+	logger   log.Logger
+	source   database.DB
+	target   database.DB
+	manifest *manifest
+	nav      *navigator
+	hooks    *hooks
+	truncate bool
+	vars     map[string]string
+}
+
+// NewCopier creates Copier instance.
+func NewCopier(logger log.Logger, source, target database.DB, cfg Config) (Copier, error) {
+	manifest, err := loadManifest(logger, cfg.ManifestFile, cfg.Vars)
+	if err != nil {
+		logger.Error("msg", "failed to create copier", "error", err)
+
+		return nil, errors.Wrap(err, "failed to create copier")
+	}
+
+	logger.Debug("msg", "create copier instance", "manifest", cfg.ManifestFile, "truncate", cfg.Truncate)
+
+	return &copier{
+		logger:   logger,
+		source:   source,
+		target:   target,
+		manifest: manifest,
+		truncate: cfg.Truncate,
+		vars:     cfg.Vars,
+	}, nil
+}
+
+func (c *copier) Copy(ctx context.Context, progress func(io.Writer) io.Writer) (err error) {
+	// the source is read in a single snapshot like dumps
+	snapshot, err := c.source.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := snapshot.Close(); err != nil {
+			c.logger.Warn("msg", "failed to close snapshot", "error", err)
+		}
+	}()
+
+	c.source = snapshot
+	c.nav = newNavigator(c.logger, snapshot, c.manifest, false)
+
+	if err := c.manifest.queryVars(c.logger, snapshot, c.vars); err != nil {
+		c.logger.Error("msg", "failed to query variables", "error", err)
+
+		return err
+	}
+
+	if err := c.manifest.materialize(c.logger, snapshot); err != nil {
+		c.logger.Error("msg", "failed to materialize selections", "error", err)
+
+		return err
+	}
+
+	if c.hooks, err = c.manifest.hooks(); err != nil {
+		return err
+	}
+
+	// Resolve all tables first, truncate needs to know them in advance
+	tables := make([]*table, 0)
+
+	for c.nav.hasNext() {
+		t, err := c.nav.next()
+		if err != nil {
+			c.logger.Error("msg", "can't fetch next table", "error", err)
+
+			return err
+		}
+
+		tables = append(tables, t)
+	}
+
+	tx, err := c.target.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if rerr := tx.Rollback(); rerr != nil {
+			c.logger.Error("msg", "failed to rollback target transaction", "error", rerr)
+		}
+	}()
+
+	if err := execActions(c.logger, tx, c.hooks.before); err != nil {
+		return err
+	}
+
+	// only tables of the manifest are truncated, referenced tables found while navigating are loaded as they are
+	if c.truncate && len(c.manifest.Tables) > 0 {
+		names := make([]string, 0, len(c.manifest.Tables))
+		for _, t := range c.manifest.Tables {
+			names = append(names, t.TableName)
+		}
+
+		if err := tx.Exec(fmt.Sprintf("TRUNCATE TABLE %s", strings.Join(names, ", "))); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range tables {
+		// Run pre and post actions on target
+		if err := execActions(c.logger, tx, t.PreActions); err != nil {
+			return err
+		}
+
+		if err := c.copyTable(ctx, tx, t, progress); err != nil {
+			return err
+		}
+
+		if err := execActions(c.logger, tx, t.afterLoad()); err != nil {
+			return err
+		}
+	}
+
+	if err := execActions(c.logger, tx, c.hooks.after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit target transaction")
+	}
+
+	return nil
+}
+
+// copyTable streams table data from source to target.
+func (c *copier) copyTable(ctx context.Context, tx database.Tx, t *table, progress func(io.Writer) io.Writer) error {
+	source, err := copyFrom(c.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+
+	go func() {
+		var w io.Writer = pw
+		if progress != nil {
+			w = progress(pw)
+		}
+
+		err := c.source.CopyTo(ctx, w, source)
+
+		// nil error closes the pipe normally
+		_ = pw.CloseWithError(err)
+
+		errCh <- err
+	}()
+
+	err = tx.CopyFrom(pr, fmt.Sprintf("COPY %s (%s) FROM STDIN", t.TableName, quoteColumns(t.Columns)))
+
+	// unblock source in case target failed before consuming all data
+	_ = pr.CloseWithError(err)
+
+	// failure of one side is propagated to the other one through the pipe
+	if serr := <-errCh; err == nil {
+		err = serr
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy table %s", t.TableName)
+	}
+
+	c.logger.Debug("msg", "table copied", "table", t.TableName)
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// fakeTarget is a target database recording the statements and the copied data of its transaction.
+type fakeTarget struct {
+	database.DB
+
+	statements []string
+	data       bytes.Buffer
+	committed  bool
+}
+
+func (f *fakeTarget) Begin(context.Context) (database.Tx, error) { return f, nil }
+
+func (f *fakeTarget) Exec(query string) error {
+	f.statements = append(f.statements, query)
+	return nil
+}
+
+func (f *fakeTarget) CopyFrom(r io.Reader, query string) error {
+	f.statements = append(f.statements, query)
+
+	_, err := io.Copy(&f.data, r)
+
+	return err
+}
+
+func (f *fakeTarget) Commit() error {
+	f.committed = true
+	return nil
+}
+
+func (f *fakeTarget) Rollback() error { return nil }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *int
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	*c.n += len(p)
+	return c.w.Write(p)
+}
+
+func TestCopy(t *testing.T) {
+	source := navigatorTestDB(map[string][]string{"orders": {"users"}, "users": nil})
+	for name, rows := range map[string]string{"users": "1\n2\n", "orders": "10\n"} {
+		table := source.tables[name]
+		table.data = rows
+		source.tables[name] = table
+	}
+
+	target := &fakeTarget{}
+
+	file := filepath.Join(t.TempDir(), ".pdd.yaml")
+	if err := ioutil.WriteFile(file, []byte("tables:\n  - table: orders\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewCopier(newTestLogger(t), source, target, Config{ManifestFile: file, Truncate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copied := 0
+
+	err = c.Copy(context.Background(), func(w io.Writer) io.Writer { return countingWriter{w: w, n: &copied} })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// users is copied first as a dependency of orders, only orders of the manifest is truncated
+	want := []string{
+		"TRUNCATE TABLE orders",
+		`COPY users ("id") FROM STDIN`,
+		`COPY orders ("id") FROM STDIN`,
+	}
+
+	if !reflect.DeepEqual(target.statements, want) || !target.committed {
+		t.Fatalf("unexpected statements %q, committed %v", target.statements, target.committed)
+	}
+
+	if copied == 0 || copied != target.data.Len() {
+		t.Fatalf("%d bytes reported as progress, %d copied", copied, target.data.Len())
+	}
+}
@@ -0,0 +1,182 @@
+package dump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+)
+
+// copyTextDecoder is a writer decoding the escapes of a single column COPY text output, see "Text Format" in
+// PostgreSQL COPY documentation. Decoded bytes are written to the underlying writer.
+type copyTextDecoder struct {
+	w io.Writer
+
+	// pending holds an incomplete escape sequence split between writes.
+	pending []byte
+}
+
+func newCopyTextDecoder(w io.Writer) *copyTextDecoder {
+	return &copyTextDecoder{w: w}
+}
+
+func (d *copyTextDecoder) Write(p []byte) (int, error) {
+	in := append(d.pending, p...)
+	out := make([]byte, 0, len(in))
+
+	i := 0
+	for i < len(in) {
+		c := in[i]
+		if c != '\\' {
+			out = append(out, c)
+			i++
+
+			continue
+		}
+
+		n, b, ok := decodeEscape(in[i:])
+		if !ok {
+			break
+		}
+
+		out = append(out, b...)
+		i += n
+	}
+
+	d.pending = append([]byte(nil), in[i:]...)
+
+	if _, err := d.w.Write(out); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// scanCopyRows runs the single column query and calls fn with the decoded value of each row. The copy is stopped when
+// fn fails, scanCopyRows returns after the copy is done, so the connection can be used again.
+func (d *dumper) scanCopyRows(ctx context.Context, query string, fn func(value []byte) error) (err error) {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		pw.CloseWithError(d.db.CopyTo(ctx, pw, query))
+	}()
+
+	defer func() {
+		// stops the copy if it is still running
+		pr.CloseWithError(err)
+		<-done
+	}()
+
+	var value bytes.Buffer
+
+	dec := newCopyTextDecoder(&value)
+	br := bufio.NewReader(pr)
+
+	for {
+		// line breaks of values are escaped, each line is a row
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			value.Reset()
+
+			if _, err := dec.Write(line); err != nil {
+				return err
+			}
+
+			if err := fn(bytes.TrimSuffix(value.Bytes(), []byte("\n"))); err != nil {
+				return err
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// decodeEscape decodes the escape sequence at the start of s, ok is false if the sequence is incomplete.
+func decodeEscape(s []byte) (n int, b []byte, ok bool) {
+	if len(s) < 2 {
+		return 0, nil, false
+	}
+
+	switch c := s[1]; c {
+	case 'b':
+		return 2, []byte{'\b'}, true
+	case 'f':
+		return 2, []byte{'\f'}, true
+	case 'n':
+		return 2, []byte{'\n'}, true
+	case 'r':
+		return 2, []byte{'\r'}, true
+	case 't':
+		return 2, []byte{'\t'}, true
+	case 'v':
+		return 2, []byte{'\v'}, true
+	case 'x':
+		return decodeDigits(s, 2, 16)
+	default:
+		if c >= '0' && c <= '7' {
+			return decodeDigits(s, 3, 8)
+		}
+
+		return 2, []byte{c}, true
+	}
+}
+
+// decodeDigits decodes up to max digits in the given base following the backslash, or "\x" for hexadecimal.
+func decodeDigits(s []byte, max, base int) (int, []byte, bool) {
+	start := 1
+	if base == 16 {
+		start = 2
+	}
+
+	v, i := 0, start
+	for ; i < len(s) && i-start < max; i++ {
+		d := digit(s[i], base)
+		if d < 0 {
+			break
+		}
+
+		v = v*base + d
+	}
+
+	// sequence may continue in the next write
+	if i == len(s) && i-start < max {
+		return 0, nil, false
+	}
+
+	// "\x" not followed by a hexadecimal digit is the letter x
+	if i == start {
+		return 2, []byte{s[1]}, true
+	}
+
+	return i, []byte{byte(v)}, true
+}
+
+func digit(c byte, base int) int {
+	var d int
+
+	switch {
+	case c >= '0' && c <= '9':
+		d = int(c - '0')
+	case c >= 'a' && c <= 'f':
+		d = int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		d = int(c-'A') + 10
+	default:
+		return -1
+	}
+
+	if d >= base {
+		return -1
+	}
+
+	return d
+}
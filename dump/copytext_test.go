@@ -0,0 +1,190 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// encodeCopyText escapes the value like COPY text output of PostgreSQL.
+func encodeCopyText(value []byte) []byte {
+	var out bytes.Buffer
+
+	for _, c := range value {
+		switch c {
+		case '\\':
+			out.WriteString(`\\`)
+		case '\b':
+			out.WriteString(`\b`)
+		case '\f':
+			out.WriteString(`\f`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		case '\v':
+			out.WriteString(`\v`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	return out.Bytes()
+}
+
+// decodeCopyText decodes the input written in chunks of the given size.
+func decodeCopyText(t *testing.T, in []byte, size int) []byte {
+	var out bytes.Buffer
+
+	dec := newCopyTextDecoder(&out)
+
+	for len(in) > 0 {
+		n := size
+		if n > len(in) {
+			n = len(in)
+		}
+
+		if written, err := dec.Write(in[:n]); err != nil || written != n {
+			t.Fatalf("write %q: %d, %v", in[:n], written, err)
+		}
+
+		in = in[n:]
+	}
+
+	return out.Bytes()
+}
+
+func TestCopyTextDecoder(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: `plain`, want: "plain"},
+		{in: `a\tb\nc\rd`, want: "a\tb\nc\rd"},
+		{in: `\b\f\v`, want: "\b\f\v"},
+		{in: `back\\slash`, want: `back\slash`},
+		{in: `\101\1022`, want: "AB2"},
+		{in: `\7`, want: "\a"},
+		{in: `\x41\x4a\x4Bz`, want: "AJKz"},
+		{in: `\x4`, want: "\x04"},
+		{in: `\xg`, want: "xg"},
+		{in: `\q\"`, want: `q"`},
+		{in: `{"name":"a\\"b"}`, want: `{"name":"a\"b"}`},
+	}
+
+	for _, tt := range tests {
+		// escapes split between writes are decoded the same way
+		for size := 1; size <= len(tt.in); size++ {
+			// a trailing escape waits for more input, the stream is ended with a new line like COPY rows
+			got := decodeCopyText(t, []byte(tt.in+"\n"), size)
+
+			if string(got) != tt.want+"\n" {
+				t.Errorf("decode %q in chunks of %d: %q, want %q", tt.in, size, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCopyTextRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 200; i++ {
+		value := make([]byte, r.Intn(64))
+
+		// mostly escaped characters and digits following them
+		chars := "\\\b\f\n\r\t\v0178xaf"
+
+		for j := range value {
+			value[j] = chars[r.Intn(len(chars))]
+			if r.Intn(4) == 0 {
+				value[j] = byte(r.Intn(256))
+			}
+		}
+
+		encoded := encodeCopyText(value)
+
+		for _, size := range []int{1, 2, 3, 5, len(encoded) + 1} {
+			if got := decodeCopyText(t, encoded, size); !bytes.Equal(got, value) {
+				t.Fatalf("round trip of %q in chunks of %d: %q", value, size, got)
+			}
+		}
+	}
+}
+
+func TestScanCopyRows(t *testing.T) {
+	values := []string{"one", "two\nlines", `back\slash`, "", "tab\tand \\n"}
+
+	rows := make([]string, 0, len(values))
+	for _, v := range values {
+		rows = append(rows, string(encodeCopyText([]byte(v)))+"\n")
+	}
+
+	d := &dumper{db: &fakeDB{copies: map[string]string{"SELECT v FROM t": strings.Join(rows, "")}}}
+
+	got := make([]string, 0, len(values))
+
+	err := d.scanCopyRows(context.Background(), "SELECT v FROM t", func(value []byte) error {
+		got = append(got, string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, values) {
+		t.Fatalf("unexpected rows %q, want %q", got, values)
+	}
+}
+
+// endlessCopyDB copies rows until the reader stops it, it records when the copy returns.
+type endlessCopyDB struct {
+	fakeDB
+
+	finished int32
+}
+
+func (f *endlessCopyDB) CopyTo(_ context.Context, w io.Writer, _ string, _ ...string) error {
+	defer atomic.StoreInt32(&f.finished, 1)
+
+	for {
+		if _, err := io.WriteString(w, "row\n"); err != nil {
+			// a slow connection cleanup
+			time.Sleep(20 * time.Millisecond)
+
+			return err
+		}
+	}
+}
+
+func TestScanCopyRowsStop(t *testing.T) {
+	db := &endlessCopyDB{}
+	d := &dumper{db: db}
+
+	errStop := errors.New("stop")
+	rows := 0
+
+	err := d.scanCopyRows(context.Background(), "SELECT v FROM t", func([]byte) error {
+		if rows++; rows == 3 {
+			return errStop
+		}
+
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if atomic.LoadInt32(&db.finished) != 1 {
+		t.Fatal("returned before the copy is done")
+	}
+}
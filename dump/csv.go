@@ -0,0 +1,18 @@
+package dump
+
+import (
+	"context"
+	"io"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// writeCSV writes table data as CSV with a header line.
+func (d *dumper) writeCSV(ctx context.Context, w io.Writer, t *table, _ []database.Column) error {
+	source, err := copySource(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	return d.db.CopyTo(ctx, w, source, "FORMAT csv", "HEADER")
+}
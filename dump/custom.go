@@ -0,0 +1,410 @@
+package dump
+
+import (
+	"bufio"
+	"compress/zlib"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PostgreSQL custom archive format constants, see pg_backup_archiver.h in PostgreSQL sources.
+const (
+	archiveMagic = "PGDMP"
+
+	// archive version 1.14 is readable by pg_restore 12 and later.
+	archiveVersionMajor = 1
+	archiveVersionMinor = 14
+	archiveVersionRev   = 0
+
+	archiveIntSize = 4
+	archiveOffSize = 8
+
+	archiveFormatCustom = 1
+
+	// zlib default compression level.
+	archiveCompression = -1
+
+	archiveBlockData = 1
+
+	archiveOffsetPosNotSet = 1
+	archiveOffsetNoData    = 3
+
+	archiveSectionPreData = 2
+	archiveSectionData    = 3
+
+	// desc of entries holding manifest statements.
+	archiveDescAction = "ACTION"
+
+	// pg_dump version recorded in the archive header.
+	archiveDumpVersion = "pdd"
+)
+
+// tocEntry is a table of contents entry of the custom archive.
+type tocEntry struct {
+	dumpID       int
+	hadDumper    bool
+	tag          string
+	desc         string
+	section      int
+	defn         string
+	copyStmt     string
+	namespace    string
+	dependencies []int
+
+	// table is set for entries having table data.
+	table *table
+	// statements is the data of entries running manifest statements.
+	statements string
+}
+
+// archiveWriter writes values in the custom archive encoding.
+type archiveWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (a *archiveWriter) writeByte(b byte) {
+	if a.err == nil {
+		a.err = a.w.WriteByte(b)
+	}
+}
+
+func (a *archiveWriter) write(p []byte) {
+	if a.err == nil {
+		_, a.err = a.w.Write(p)
+	}
+}
+
+// writeInt writes a sign byte followed by the absolute value in little endian.
+func (a *archiveWriter) writeInt(i int) {
+	sign := byte(0)
+	if i < 0 {
+		sign, i = 1, -i
+	}
+
+	buf := make([]byte, archiveIntSize)
+	binary.LittleEndian.PutUint32(buf, uint32(i))
+
+	a.writeByte(sign)
+	a.write(buf)
+}
+
+// writeStr writes length prefixed string, empty string is written as NULL.
+func (a *archiveWriter) writeStr(s string) {
+	if s == "" {
+		a.writeInt(-1)
+		return
+	}
+
+	a.writeInt(len(s))
+	a.write([]byte(s))
+}
+
+// writeOffset writes data offset flag followed by an unknown position, offsets can't be set on a stream.
+func (a *archiveWriter) writeOffset(flag byte) {
+	a.writeByte(flag)
+	a.write(make([]byte, archiveOffSize))
+}
+
+// Write writes p as a length prefixed data chunk.
+func (a *archiveWriter) Write(p []byte) (int, error) {
+	// zero length chunk marks the end of the data block
+	if len(p) == 0 {
+		return 0, a.err
+	}
+
+	a.writeInt(len(p))
+	a.write(p)
+
+	if a.err != nil {
+		return 0, a.err
+	}
+
+	return len(p), nil
+}
+
+// dumpCustom dumps the database as a pg_restore compatible custom archive.
+func (d *dumper) dumpCustom(ctx context.Context, w io.Writer) error {
+	info, err := d.db.GetInfo()
+	if err != nil {
+		return err
+	}
+
+	entries, err := d.customEntries()
+	if err != nil {
+		return err
+	}
+
+	a := &archiveWriter{w: bufio.NewWriter(w)}
+
+	writeCustomHeader(a, info.Name, info.ServerVersion, d.now())
+	writeCustomTOC(a, entries)
+
+	// data blocks follow the toc in the same order
+	for _, e := range entries {
+		if !e.hadDumper {
+			continue
+		}
+
+		if err := d.writeCustomData(ctx, a, e); err != nil {
+			return err
+		}
+	}
+
+	if a.err != nil {
+		return a.err
+	}
+
+	return a.w.Flush()
+}
+
+// customEntries creates toc entries of the archive in navigator order.
+func (d *dumper) customEntries() ([]*tocEntry, error) {
+	entries := []*tocEntry{
+		{
+			dumpID:  1,
+			tag:     "ENCODING",
+			desc:    "ENCODING",
+			section: archiveSectionPreData,
+			defn:    "SET client_encoding = 'UTF8';\n",
+		},
+		{
+			dumpID:  2,
+			tag:     "STDSTRINGS",
+			desc:    "STDSTRINGS",
+			section: archiveSectionPreData,
+			defn:    "SET standard_conforming_strings = 'on';\n",
+		},
+	}
+
+	ids := make(map[string]int)
+
+	// before statements precede all table data, after statements follow the data and post actions of all tables
+	before := actionEntry(&entries, "before", d.hooks.before, nil)
+	loaded := make([]int, 0)
+
+	for d.nav.hasNext() {
+		t, err := d.nav.next()
+		if err != nil {
+			d.logger.Error("msg", "can't fetch next table", "error", err)
+
+			return nil, err
+		}
+
+		namespace, name := splitTableName(t.TableName)
+
+		// table data depends on data of the referenced tables and the statements executed before it
+		deps := append([]int(nil), before...)
+		for _, dep := range t.dependencies {
+			if id, ok := ids[dep]; ok {
+				deps = append(deps, id)
+			}
+		}
+
+		deps = append(deps, actionEntry(&entries, name+" pre_actions", t.PreActions, before)...)
+
+		entry := &tocEntry{
+			dumpID:       len(entries) + 1,
+			hadDumper:    true,
+			tag:          name,
+			desc:         "TABLE DATA",
+			section:      archiveSectionData,
+			copyStmt:     fmt.Sprintf("COPY %s (%s) FROM stdin;\n", t.TableName, quoteColumns(t.Columns)),
+			namespace:    namespace,
+			dependencies: deps,
+			table:        t,
+		}
+
+		ids[t.TableName] = entry.dumpID
+		entries = append(entries, entry)
+		loaded = append(loaded, entry.dumpID)
+
+		// sequence resets are restored with the data like pg_dump sequence values
+		resets := make([]int, 0, len(t.sequences))
+
+		for i, seq := range t.sequences {
+			seqNamespace, seqName := splitTableName(seq.Name)
+
+			e := &tocEntry{
+				dumpID:       len(entries) + 1,
+				tag:          seqName,
+				desc:         "SEQUENCE SET",
+				section:      archiveSectionData,
+				defn:         t.resets[i] + ";\n",
+				namespace:    seqNamespace,
+				dependencies: []int{entry.dumpID},
+			}
+
+			entries = append(entries, e)
+			resets = append(resets, e.dumpID)
+		}
+
+		loaded = append(loaded, resets...)
+		loaded = append(loaded, actionEntry(&entries, name+" post_actions", t.PostActions,
+			append([]int{entry.dumpID}, resets...))...)
+	}
+
+	actionEntry(&entries, "after", d.hooks.after, loaded)
+
+	return entries, nil
+}
+
+// actionEntry appends an entry running the statements after the given entries and returns its id, nothing is appended
+// without statements. Statements are the data of the entry, which pg_restore runs one by one like the INSERT statements
+// of pg_dump --inserts, so data-only restores run them too. Entries are in public schema, pg_restore sets it as search
+// path of the statements like the plain format.
+func actionEntry(entries *[]*tocEntry, tag string, actions []string, deps []int) []int {
+	if len(actions) == 0 {
+		return nil
+	}
+
+	var statements strings.Builder
+	for _, action := range actions {
+		fmt.Fprintf(&statements, "%s;\n\n", action)
+	}
+
+	e := &tocEntry{
+		dumpID:       len(*entries) + 1,
+		hadDumper:    true,
+		tag:          tag,
+		desc:         archiveDescAction,
+		section:      archiveSectionData,
+		namespace:    "public",
+		dependencies: append([]int(nil), deps...),
+		statements:   statements.String(),
+	}
+
+	*entries = append(*entries, e)
+
+	return []int{e.dumpID}
+}
+
+// writeCustomData writes the table data or the statements of the entry as a zlib compressed data block.
+func (d *dumper) writeCustomData(ctx context.Context, a *archiveWriter, e *tocEntry) error {
+	a.writeByte(archiveBlockData)
+	a.writeInt(e.dumpID)
+
+	zw := zlib.NewWriter(a)
+
+	if e.table == nil {
+		if _, err := io.WriteString(zw, e.statements); err != nil {
+			return err
+		}
+	} else if err := d.writeCustomTableData(ctx, zw, e.table); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return errors.Wrapf(err, "failed to compress data of %s", e.tag)
+	}
+
+	// end of data block
+	a.writeInt(0)
+
+	return a.err
+}
+
+// writeCustomTableData writes the COPY data of the table.
+func (d *dumper) writeCustomTableData(ctx context.Context, w io.Writer, t *table) error {
+	source, err := copyFrom(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	if err := d.db.CopyTo(ctx, w, source); err != nil {
+		return err
+	}
+
+	// pg_dump includes end of copy marker to the data
+	if _, err := fmt.Fprint(w, "\\.\n\n"); err != nil {
+		d.logger.Error("msg", "failed to write table footer", "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// writeCustomHeader writes archive header.
+func writeCustomHeader(a *archiveWriter, dbname, serverVersion string, t time.Time) {
+	a.write([]byte(archiveMagic))
+	a.writeByte(archiveVersionMajor)
+	a.writeByte(archiveVersionMinor)
+	a.writeByte(archiveVersionRev)
+	a.writeByte(archiveIntSize)
+	a.writeByte(archiveOffSize)
+	a.writeByte(archiveFormatCustom)
+	a.writeInt(archiveCompression)
+
+	// creation date as struct tm
+	a.writeInt(t.Second())
+	a.writeInt(t.Minute())
+	a.writeInt(t.Hour())
+	a.writeInt(t.Day())
+	a.writeInt(int(t.Month()) - 1)
+	a.writeInt(t.Year() - 1900)
+	a.writeInt(-1)
+
+	a.writeStr(dbname)
+	a.writeStr(serverVersion)
+	a.writeStr(archiveDumpVersion)
+}
+
+// writeCustomTOC writes table of contents of the archive.
+func writeCustomTOC(a *archiveWriter, entries []*tocEntry) {
+	a.writeInt(len(entries))
+
+	for _, e := range entries {
+		a.writeInt(e.dumpID)
+
+		if e.hadDumper {
+			a.writeInt(1)
+		} else {
+			a.writeInt(0)
+		}
+
+		// catalog id, table oid and oid
+		a.writeStr("0")
+		a.writeStr("0")
+
+		a.writeStr(e.tag)
+		a.writeStr(e.desc)
+		a.writeInt(e.section)
+		a.writeStr(e.defn)
+		a.writeStr("") // drop statement
+		a.writeStr(e.copyStmt)
+		a.writeStr(e.namespace)
+		a.writeStr("") // tablespace
+		a.writeStr("") // table access method
+		a.writeStr("") // owner
+		a.writeStr("false")
+
+		for _, dep := range e.dependencies {
+			a.writeStr(strconv.Itoa(dep))
+		}
+
+		a.writeStr("") // end of dependencies
+
+		if e.hadDumper {
+			a.writeOffset(archiveOffsetPosNotSet)
+		} else {
+			a.writeOffset(archiveOffsetNoData)
+		}
+	}
+}
+
+// splitTableName splits schema qualified table name, unqualified tables are assumed to be in public schema.
+func splitTableName(name string) (string, string) {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return strings.Trim(name[:i], `"`), strings.Trim(name[i+1:], `"`)
+	}
+
+	return "public", strings.Trim(name, `"`)
+}
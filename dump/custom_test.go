@@ -0,0 +1,321 @@
+package dump
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// archiveReader reads values in the custom archive encoding, the counterpart of archiveWriter.
+type archiveReader struct {
+	t *testing.T
+	r io.Reader
+}
+
+func (a *archiveReader) read(n int) []byte {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(a.r, buf); err != nil {
+		a.t.Fatalf("unexpected end of archive: %v", err)
+	}
+
+	return buf
+}
+
+func (a *archiveReader) readByte() byte {
+	return a.read(1)[0]
+}
+
+func (a *archiveReader) readInt() int {
+	sign := a.readByte()
+	i := int(binary.LittleEndian.Uint32(a.read(archiveIntSize)))
+
+	if sign != 0 {
+		return -i
+	}
+
+	return i
+}
+
+func (a *archiveReader) readStr() string {
+	n := a.readInt()
+	if n < 0 {
+		return ""
+	}
+
+	return string(a.read(n))
+}
+
+func readArchive(t *testing.T, data []byte) ([]tocEntry, map[int]string) {
+	a := &archiveReader{t: t, r: bytes.NewReader(data)}
+
+	if magic := string(a.read(len(archiveMagic))); magic != archiveMagic {
+		t.Fatalf("unexpected magic %q", magic)
+	}
+
+	if v := a.read(3); v[0] != archiveVersionMajor || v[1] != archiveVersionMinor || v[2] != archiveVersionRev {
+		t.Fatalf("unexpected version %v", v)
+	}
+
+	if a.readByte() != archiveIntSize || a.readByte() != archiveOffSize || a.readByte() != archiveFormatCustom {
+		t.Fatal("unexpected int size, offset size or format")
+	}
+
+	if c := a.readInt(); c != archiveCompression {
+		t.Fatalf("unexpected compression %d", c)
+	}
+
+	// creation date as struct tm
+	for i := 0; i < 7; i++ {
+		a.readInt()
+	}
+
+	if header := []string{a.readStr(), a.readStr(), a.readStr()}; !reflect.DeepEqual(header,
+		[]string{"app", "16.4", archiveDumpVersion}) {
+		t.Fatalf("unexpected header %v", header)
+	}
+
+	entries := make([]tocEntry, a.readInt())
+
+	for i := range entries {
+		e := &entries[i]
+
+		e.dumpID = a.readInt()
+		e.hadDumper = a.readInt() == 1
+
+		if oids := a.readStr() + " " + a.readStr(); oids != "0 0" {
+			t.Fatalf("unexpected oids %q", oids)
+		}
+
+		e.tag, e.desc, e.section, e.defn = a.readStr(), a.readStr(), a.readInt(), a.readStr()
+
+		if drop := a.readStr(); drop != "" {
+			t.Fatalf("unexpected drop statement %q", drop)
+		}
+
+		e.copyStmt, e.namespace = a.readStr(), a.readStr()
+
+		// tablespace, table access method, owner
+		for j := 0; j < 3; j++ {
+			if s := a.readStr(); s != "" {
+				t.Fatalf("unexpected value %q", s)
+			}
+		}
+
+		if withOids := a.readStr(); withOids != "false" {
+			t.Fatalf("unexpected with oids %q", withOids)
+		}
+
+		for dep := a.readStr(); dep != ""; dep = a.readStr() {
+			id, err := strconv.Atoi(dep)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			e.dependencies = append(e.dependencies, id)
+		}
+
+		flag, offset := a.readByte(), a.read(archiveOffSize)
+
+		if e.hadDumper && flag != archiveOffsetPosNotSet || !e.hadDumper && flag != archiveOffsetNoData ||
+			!bytes.Equal(offset, make([]byte, archiveOffSize)) {
+			t.Fatalf("unexpected offset %d %v of entry %d", flag, offset, e.dumpID)
+		}
+	}
+
+	blocks := make(map[int]string)
+
+	for {
+		var typ [1]byte
+		if _, err := a.r.Read(typ[:]); err == io.EOF {
+			break
+		}
+
+		if typ[0] != archiveBlockData {
+			t.Fatalf("unexpected block type %d", typ[0])
+		}
+
+		id := a.readInt()
+
+		var compressed bytes.Buffer
+		for n := a.readInt(); n != 0; n = a.readInt() {
+			compressed.Write(a.read(n))
+		}
+
+		zr, err := zlib.NewReader(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blocks[id] = string(data)
+	}
+
+	return entries, blocks
+}
+
+// dumpCustomArchive returns the custom archive of a dump with actions, sequences and dependent tables.
+func dumpCustomArchive(t *testing.T) []byte {
+	db := &fakeDB{tables: map[string]fakeTable{
+		"users": {
+			columns: []database.ColumnDefinition{
+				{Name: "id", Type: "integer", NotNull: true, Default: "nextval('users_id_seq'::regclass)"},
+				{Name: "name", Type: "text"},
+			},
+			sequences: []database.Sequence{{Name: "users_id_seq", Column: "id"}},
+			data:      "1\talice\n2\tbob\\tby\n",
+		},
+		"sales.orders": {
+			columns: []database.ColumnDefinition{
+				{Name: "id", Type: "bigint", NotNull: true},
+				{Name: "user_id", Type: "integer"},
+			},
+			deps: []string{"users"},
+			data: "10\t1\n11\t\\N\n",
+		},
+	}}
+
+	out := testDump(t, db, `
+before:
+  - SET session_replication_role = replica
+after:
+  - ANALYZE
+tables:
+  - table: sales.orders
+    pre_actions:
+      - DELETE FROM sales.orders
+    post_actions:
+      - ANALYZE sales.orders
+`, Config{Format: FormatCustom})
+
+	if suffixes := out.suffixes(); !reflect.DeepEqual(suffixes, []string{".dump"}) {
+		t.Fatalf("unexpected objects %v", suffixes)
+	}
+
+	return out.objects[".dump"].Bytes()
+}
+
+func TestDumpCustom(t *testing.T) {
+	entries, blocks := readArchive(t, dumpCustomArchive(t))
+
+	want := []tocEntry{
+		{dumpID: 1, tag: "ENCODING", desc: "ENCODING", section: archiveSectionPreData,
+			defn: "SET client_encoding = 'UTF8';\n"},
+		{dumpID: 2, tag: "STDSTRINGS", desc: "STDSTRINGS", section: archiveSectionPreData,
+			defn: "SET standard_conforming_strings = 'on';\n"},
+		{dumpID: 3, hadDumper: true, tag: "before", desc: archiveDescAction, section: archiveSectionData,
+			namespace: "public"},
+		{dumpID: 4, hadDumper: true, tag: "users", desc: "TABLE DATA", section: archiveSectionData,
+			copyStmt: "COPY users (\"id\", \"name\") FROM stdin;\n", namespace: "public", dependencies: []int{3}},
+		{dumpID: 5, tag: "users_id_seq", desc: "SEQUENCE SET", section: archiveSectionData,
+			defn:      "SELECT pg_catalog.setval('users_id_seq', MAX(\"id\")) FROM users HAVING MAX(\"id\") IS NOT NULL;\n",
+			namespace: "public", dependencies: []int{4}},
+		{dumpID: 6, hadDumper: true, tag: "orders pre_actions", desc: archiveDescAction, section: archiveSectionData,
+			namespace: "public", dependencies: []int{3}},
+		{dumpID: 7, hadDumper: true, tag: "orders", desc: "TABLE DATA", section: archiveSectionData,
+			copyStmt: "COPY sales.orders (\"id\", \"user_id\") FROM stdin;\n", namespace: "sales",
+			dependencies: []int{3, 4, 6}},
+		{dumpID: 8, hadDumper: true, tag: "orders post_actions", desc: archiveDescAction, section: archiveSectionData,
+			namespace: "public", dependencies: []int{7}},
+		{dumpID: 9, hadDumper: true, tag: "after", desc: archiveDescAction, section: archiveSectionData,
+			namespace: "public", dependencies: []int{4, 5, 7, 8}},
+	}
+
+	if len(entries) != len(want) {
+		t.Fatalf("unexpected number of entries %d, want %d\n%+v", len(entries), len(want), entries)
+	}
+
+	for i := range want {
+		if !reflect.DeepEqual(entries[i], want[i]) {
+			t.Errorf("unexpected entry\n%+v\nwant\n%+v", entries[i], want[i])
+		}
+	}
+
+	// data blocks hold the COPY output of the tables with the end of copy marker, or the statements of actions
+	wantBlocks := map[int]string{
+		3: "SET session_replication_role = replica;\n\n",
+		4: "1\talice\n2\tbob\\tby\n\\.\n\n",
+		6: "DELETE FROM sales.orders;\n\n",
+		7: "10\t1\n11\t\\N\n\\.\n\n",
+		8: "ANALYZE sales.orders;\n\n",
+		9: "ANALYZE;\n\n",
+	}
+
+	if !reflect.DeepEqual(blocks, wantBlocks) {
+		t.Fatalf("unexpected data blocks %q, want %q", blocks, wantBlocks)
+	}
+}
+
+// TestDumpCustomPgRestore checks the archive with pg_restore when it is installed.
+func TestDumpCustomPgRestore(t *testing.T) {
+	bin, err := exec.LookPath("pg_restore")
+	if err != nil {
+		t.Skip("pg_restore not found")
+	}
+
+	file := filepath.Join(t.TempDir(), "test.dump")
+	if err := ioutil.WriteFile(file, dumpCustomArchive(t), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := exec.Command(bin, "--list", file).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pg_restore --list: %v\n%s", err, list)
+	}
+
+	entries := []string{
+		"1; 0 0 ENCODING - ENCODING",
+		"2; 0 0 STDSTRINGS - STDSTRINGS",
+		"3; 0 0 ACTION public before",
+		"4; 0 0 TABLE DATA public users",
+		"5; 0 0 SEQUENCE SET public users_id_seq",
+		"6; 0 0 ACTION public orders pre_actions",
+		"7; 0 0 TABLE DATA sales orders",
+		"8; 0 0 ACTION public orders post_actions",
+		"9; 0 0 ACTION public after",
+	}
+
+	for _, e := range entries {
+		if !strings.Contains(string(list), "\n"+e) {
+			t.Errorf("entry %q not listed\n%s", e, list)
+		}
+	}
+
+	// data-only restores run the statements of the manifest in dump order
+	script, err := exec.Command(bin, "--data-only", "--file", "-", file).CombinedOutput()
+	if err != nil {
+		t.Fatalf("pg_restore --data-only: %v\n%s", err, script)
+	}
+
+	rest := string(script)
+
+	for _, s := range []string{
+		"SET session_replication_role = replica;",
+		"COPY users (\"id\", \"name\") FROM stdin;\n1\talice\n",
+		"SELECT pg_catalog.setval('users_id_seq'",
+		"DELETE FROM sales.orders;",
+		"COPY sales.orders (\"id\", \"user_id\") FROM stdin;\n10\t1\n",
+		"ANALYZE sales.orders;",
+		"ANALYZE;",
+	} {
+		i := strings.Index(rest, s)
+		if i < 0 {
+			t.Fatalf("%q not found in order in the restore script\n%s", s, script)
+		}
+
+		rest = rest[i+len(s):]
+	}
+}
@@ -2,14 +2,16 @@ package dump
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/aweris/postgres-data-dump/database"
 	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/aweris/postgres-data-dump/internal/parquet"
 	"github.com/pkg/errors"
 )
 
@@ -53,8 +55,8 @@ COMMIT;
 
 // Dumper provides functionality to dump database with given manifest configuration.
 type Dumper interface {
-	// creates database dump
-	Dump(w io.Writer) error
+	// creates database dump in the output, dump is cancelled when context is done
+	Dump(ctx context.Context, out Output) error
 }
 
 type dumper struct {
@@ -62,30 +64,114 @@ type dumper struct {
 	db       database.DB
 	manifest *manifest
 	nav      *navigator
+	hooks    *hooks
+	format   string
+	cfg      Config
 }
 
 // NewDumper creates Dumper instance.
 func NewDumper(logger log.Logger, db database.DB, cfg Config) (Dumper, error) {
-	manifest, err := loadManifest(logger, cfg.ManifestFile)
+	switch cfg.Format {
+	case FormatPlain, FormatCustom, FormatCSV, FormatBinary, FormatJSONL, FormatParquet, FormatSQLite, FormatInserts,
+		FormatTar:
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	if cfg.Format == FormatInserts && cfg.RowsPerInsert < 1 {
+		return nil, ErrInvalidRowsPerInsert
+	}
+
+	if cfg.Format == FormatParquet {
+		switch cfg.ParquetCompression {
+		case parquet.CompressionNone, parquet.CompressionSnappy, parquet.CompressionZstd:
+		default:
+			return nil, ErrUnknownCompression
+		}
+	}
+
+	if cfg.Format == FormatTar {
+		switch cfg.TarCompression {
+		case TarCompressionNone, TarCompressionGzip, TarCompressionZstd:
+		default:
+			return nil, ErrUnknownCompression
+		}
+	}
+
+	manifest, err := loadManifest(logger, cfg.ManifestFile, cfg.Vars)
 	if err != nil {
 		logger.Error("msg", "failed to create exporter", "error", err)
 
 		return nil, errors.Wrap(err, "failed to create exporter")
 	}
 
-	nav := newNavigator(logger, db, manifest)
-
-	logger.Debug("msg", "create exporter instance", "manifest", cfg.ManifestFile)
+	logger.Debug("msg", "create exporter instance", "manifest", cfg.ManifestFile, "format", cfg.Format)
 
 	return &dumper{
 		logger:   logger,
 		db:       db,
 		manifest: manifest,
-		nav:      nav,
+		format:   cfg.Format,
+		cfg:      cfg,
 	}, nil
 }
 
-func (d *dumper) Dump(w io.Writer) error {
+func (d *dumper) Dump(ctx context.Context, out Output) error {
+	// the whole dump sees the database as of its start
+	snapshot, err := d.db.Snapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := snapshot.Close(); err != nil {
+			d.logger.Warn("msg", "failed to close snapshot", "error", err)
+		}
+	}()
+
+	d.db = snapshot
+	d.nav = newNavigator(d.logger, snapshot, d.manifest, d.cfg.Deterministic)
+
+	if err := d.manifest.queryVars(d.logger, snapshot, d.cfg.Vars); err != nil {
+		d.logger.Error("msg", "failed to query variables", "error", err)
+
+		return err
+	}
+
+	if err := d.manifest.materialize(d.logger, snapshot); err != nil {
+		d.logger.Error("msg", "failed to materialize selections", "error", err)
+
+		return err
+	}
+
+	if d.hooks, err = d.manifest.hooks(); err != nil {
+		return err
+	}
+
+	switch d.format {
+	case FormatCustom:
+		return writeObject(out, ".dump", func(w io.Writer) error { return d.dumpCustom(ctx, w) })
+	case FormatInserts:
+		return writeObject(out, ".sql", func(w io.Writer) error { return d.dumpInserts(ctx, w) })
+	case FormatSQLite:
+		return writeObject(out, ".sqlite", func(w io.Writer) error { return d.dumpSQLite(ctx, w) })
+	case FormatTar:
+		return writeObject(out, tarExt(d.cfg.TarCompression), func(w io.Writer) error { return d.dumpTar(ctx, w) })
+	case FormatCSV:
+		return d.dumpFiles(ctx, out, ".csv", d.writeCSV)
+	case FormatBinary:
+		return d.dumpFiles(ctx, out, ".bin", d.writeBinary)
+	case FormatJSONL:
+		return d.dumpFiles(ctx, out, ".jsonl", d.writeJSONL)
+	case FormatParquet:
+		return d.dumpFiles(ctx, out, ".parquet", d.writeParquet)
+	default:
+		return writeObject(out, ".sql", func(w io.Writer) error { return d.dumpPlain(ctx, w) })
+	}
+}
+
+// dumpPlain dumps the database as a plain SQL script.
+func (d *dumper) dumpPlain(ctx context.Context, w io.Writer) error {
 	// Print dump header
 	if _, err := fmt.Fprint(w, dumpHeader); err != nil {
 		d.logger.Error("msg", "failed to write dump header", "error", err)
@@ -100,6 +186,13 @@ func (d *dumper) Dump(w io.Writer) error {
 		return err
 	}
 
+	// Print before statements
+	if err := writeActions(w, d.hooks.before); err != nil {
+		d.logger.Error("msg", "failed to write before statements", "error", err)
+
+		return err
+	}
+
 	// Print tables
 	for d.nav.hasNext() {
 		t, err := d.nav.next()
@@ -109,14 +202,17 @@ func (d *dumper) Dump(w io.Writer) error {
 			return err
 		}
 
-		// Wrap column names with quote
-		quoted := make([]string, 0)
-		for _, v := range t.Columns {
-			quoted = append(quoted, strconv.Quote(v))
-		}
+		cols := quoteColumns(t.Columns)
+
+		// Prefer starting a new part of split dumps with the table
+		markBoundary(w)
 
-		// Join columns
-		cols := strings.Join(quoted, ", ")
+		// Print pre actions
+		if err := writeActions(w, t.PreActions); err != nil {
+			d.logger.Error("msg", "failed to write table pre actions", "table", t.TableName, "error", err)
+
+			return err
+		}
 
 		// Print table copy statement with stdin option
 		if _, err := fmt.Fprintf(w, tableHeader, t.TableName, t.TableName, cols); err != nil {
@@ -131,7 +227,7 @@ func (d *dumper) Dump(w io.Writer) error {
 			return err
 		}
 
-		if err := d.db.CopyTo(w, source); err != nil {
+		if err := d.db.CopyTo(ctx, w, source); err != nil {
 			return err
 		}
 
@@ -143,15 +239,20 @@ func (d *dumper) Dump(w io.Writer) error {
 		}
 
 		// Print post actions
-		for _, action := range t.PostActions {
-			if _, err := fmt.Fprintf(w, "\n%s;\n", action); err != nil {
-				d.logger.Error("msg", "failed to write table action", "action", action, "error", err)
+		if err := writeActions(w, t.afterLoad()); err != nil {
+			d.logger.Error("msg", "failed to write table post actions", "table", t.TableName, "error", err)
 
-				return err
-			}
+			return err
 		}
 	}
 
+	// Print after statements
+	if err := writeActions(w, d.hooks.after); err != nil {
+		d.logger.Error("msg", "failed to write after statements", "error", err)
+
+		return err
+	}
+
 	// Print dump footer
 	if _, err := fmt.Fprint(w, dumpFooter); err != nil {
 		d.logger.Error("msg", "failed to write dump footer", "error", err)
@@ -162,14 +263,63 @@ func (d *dumper) Dump(w io.Writer) error {
 	return nil
 }
 
-// copyFrom returns prepared table statement from table name or rendered query.
+// quoteColumns wraps column names with quote and joins them.
+func quoteColumns(columns []string) string {
+	quoted := make([]string, 0)
+	for _, v := range columns {
+		quoted = append(quoted, quoteIdent(v))
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// quoteIdent quotes the name as an SQL identifier, double quotes in the name are doubled.
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// copyFrom returns prepared table statement from table name or rendered query, selecting the select list of the
+// table and ordered when the table has an order.
 func copyFrom(m *manifest, t *table) (string, error) {
-	if t.Query == "" {
-		return t.TableName, nil
+	from := t.TableName
+
+	if t.Query != "" {
+		query, err := renderQuery(m, t.Query)
+		if err != nil {
+			return "", err
+		}
+
+		from = fmt.Sprintf("(%s)", query)
+	}
+
+	if t.orderBy == "" && len(t.selectList) == 0 {
+		return from, nil
+	}
+
+	columns := "*"
+	if len(t.selectList) > 0 {
+		columns = strings.Join(t.selectList, ", ")
+	} else if t.Query == "" {
+		columns = quoteColumns(t.Columns)
+	}
+
+	if t.orderBy == "" {
+		return fmt.Sprintf("(SELECT %s FROM %s AS s)", columns, from), nil
 	}
 
-	// Create new template from query
-	tmpl, err := template.New("query").Parse(t.Query)
+	return fmt.Sprintf("(SELECT %s FROM %s AS s ORDER BY %s)", columns, from, t.orderBy), nil
+}
+
+// renderQuery renders the query template with the manifest variables.
+func renderQuery(m *manifest, query string) (string, error) {
+	return renderTemplate(m, query, m.sel)
+}
+
+// renderTemplate renders the query template with the manifest variables and the given sel function.
+func renderTemplate(m *manifest, query string, sel func(name string) (string, error)) (string, error) {
+	// Create new template from query, undefined variables are errors instead of rendering as "<no value>"
+	tmpl, err := template.New("query").Option("missingkey=error").Funcs(queryFuncs).
+		Funcs(template.FuncMap{"sel": sel}).Parse(query)
 	if err != nil {
 		return "", err
 	}
@@ -180,5 +330,14 @@ func copyFrom(m *manifest, t *table) (string, error) {
 		return "", err
 	}
 
-	return fmt.Sprintf("(%s)", out.String()), nil
+	return out.String(), nil
+}
+
+// now returns the creation time of the dump, deterministic dumps use the Unix epoch in UTC.
+func (d *dumper) now() time.Time {
+	if d.cfg.Deterministic {
+		return time.Unix(0, 0).UTC()
+	}
+
+	return time.Now()
 }
@@ -0,0 +1,172 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// fakeTable is a table of the fakeDB.
+type fakeTable struct {
+	columns   []database.ColumnDefinition
+	deps      []string
+	sequences []database.Sequence
+	// constraints are served by the catalogDB.
+	constraints []database.Constraint
+	// data is the COPY text output of the table.
+	data string
+}
+
+// fakeDB is an in-memory database serving the tables, it's also its own snapshot. Unused methods panic.
+type fakeDB struct {
+	database.DB
+
+	tables map[string]fakeTable
+	// copies holds the COPY output of queries which aren't plain table names.
+	copies map[string]string
+}
+
+func (f *fakeDB) Snapshot(context.Context) (database.Snapshot, error) { return f, nil }
+
+func (f *fakeDB) Exec(string) error { return nil }
+
+func (f *fakeDB) Close() error { return nil }
+
+func (f *fakeDB) GetInfo() (*database.Info, error) {
+	return &database.Info{Name: "app", ServerVersion: "16.4"}, nil
+}
+
+func (f *fakeDB) GetTables() ([]database.Table, error) {
+	tables := make([]database.Table, 0, len(f.tables))
+	for name := range f.tables {
+		tables = append(tables, database.Table{Name: name})
+	}
+
+	sort.Slice(tables, func(i, j int) bool { return tables[i].Name < tables[j].Name })
+
+	return tables, nil
+}
+
+func (f *fakeDB) table(name string) (fakeTable, error) {
+	t, ok := f.tables[name]
+	if !ok {
+		return t, errors.Errorf("unknown table %s", name)
+	}
+
+	return t, nil
+}
+
+func (f *fakeDB) GetTableDependencies(name string) ([]string, error) {
+	t, err := f.table(name)
+	return t.deps, err
+}
+
+func (f *fakeDB) GetTableColumns(name string) ([]string, error) {
+	t, err := f.table(name)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, 0, len(t.columns))
+	for _, c := range t.columns {
+		columns = append(columns, c.Name)
+	}
+
+	return columns, nil
+}
+
+func (f *fakeDB) GetTableDefinition(name string) ([]database.ColumnDefinition, error) {
+	t, err := f.table(name)
+	return t.columns, err
+}
+
+func (f *fakeDB) GetTableSequences(name string) ([]database.Sequence, error) {
+	t, err := f.table(name)
+	return t.sequences, err
+}
+
+func (f *fakeDB) CopyTo(_ context.Context, w io.Writer, query string, _ ...string) error {
+	data, ok := f.copies[query]
+	if !ok {
+		t, err := f.table(query)
+		if err != nil {
+			return err
+		}
+
+		data = t.data
+	}
+
+	_, err := io.WriteString(w, data)
+
+	return err
+}
+
+// memOutput is an Output keeping the objects in memory.
+type memOutput struct {
+	objects map[string]*bytes.Buffer
+}
+
+func (o *memOutput) Create(suffix string) (io.WriteCloser, error) {
+	if o.objects == nil {
+		o.objects = make(map[string]*bytes.Buffer)
+	}
+
+	buf := &bytes.Buffer{}
+	o.objects[suffix] = buf
+
+	return nopCloser{buf}, nil
+}
+
+func (o *memOutput) suffixes() []string {
+	suffixes := make([]string, 0, len(o.objects))
+	for suffix := range o.objects {
+		suffixes = append(suffixes, suffix)
+	}
+
+	sort.Strings(suffixes)
+
+	return suffixes
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+func newTestLogger(t *testing.T) log.Logger {
+	logger, err := log.NewLogger(log.LevelInfo, log.FormatFmt, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return logger
+}
+
+// testDump dumps the database with the manifest in the given format and returns the written objects.
+func testDump(t *testing.T, db database.DB, manifest string, cfg Config) *memOutput {
+	cfg.ManifestFile = filepath.Join(t.TempDir(), ".pdd.yaml")
+
+	if err := ioutil.WriteFile(cfg.ManifestFile, []byte(strings.TrimSpace(manifest)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDumper(newTestLogger(t), db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := &memOutput{}
+	if err := d.Dump(context.Background(), out); err != nil {
+		t.Fatal(err)
+	}
+
+	return out
+}
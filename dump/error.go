@@ -0,0 +1,22 @@
+package dump
+
+import "errors"
+
+var (
+	ErrUnknownFormat              = errors.New("unknown dump format")
+	ErrUnknownCompression         = errors.New("unknown compression")
+	ErrNotRestorable              = errors.New("dump format can't be restored")
+	ErrInvalidRowsPerInsert       = errors.New("rows per insert must be positive")
+	ErrManifestCycle              = errors.New("manifest extends or includes itself")
+	ErrNoManifestMatch            = errors.New("no manifest file matches")
+	ErrUndefinedEnv               = errors.New("undefined environment variable")
+	ErrNotSingleValue             = errors.New("variable query must return at most one row")
+	ErrUnknownSelection           = errors.New("unknown selection or used before it is materialized")
+	ErrSelectionInStatement       = errors.New("selections can only be used in queries")
+	ErrInvalidManifest            = errors.New("invalid manifest")
+	ErrUnsupportedManifestVersion = errors.New("unsupported manifest version")
+	ErrUnknownColumn              = errors.New("unknown column")
+	ErrRequiredColumn             = errors.New("column is required")
+	ErrNoMoreTables               = errors.New("no more tables to navigate")
+	ErrDuplicateTableName         = errors.New("tables have the same name in sqlite")
+)
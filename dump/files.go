@@ -0,0 +1,117 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// name of the manifest object of the dumps having a file per table.
+const filesManifestName = "manifest.json"
+
+// filesManifest describes a dump having a file per table.
+type filesManifest struct {
+	Format   string      `json:"format"`
+	Database string      `json:"database"`
+	Created  time.Time   `json:"created"`
+	Before   []string    `json:"before,omitempty"`
+	Tables   []fileEntry `json:"tables"`
+	After    []string    `json:"after,omitempty"`
+}
+
+// fileEntry describes the file of a table.
+type fileEntry struct {
+	Table       string            `json:"table"`
+	File        string            `json:"file"`
+	Columns     []database.Column `json:"columns"`
+	PreActions  []string          `json:"pre_actions,omitempty"`
+	PostActions []string          `json:"post_actions,omitempty"`
+}
+
+// tableFileWriter writes table data to the given writer.
+type tableFileWriter func(ctx context.Context, w io.Writer, t *table, columns []database.Column) error
+
+// dumpFiles dumps each table the navigator yields to a separate file with the given extension and writes a manifest
+// describing them, all under the dump directory.
+func (d *dumper) dumpFiles(ctx context.Context, out Output, ext string, fn tableFileWriter) error {
+	info, err := d.db.GetInfo()
+	if err != nil {
+		return err
+	}
+
+	m := filesManifest{
+		Format:   d.format,
+		Database: info.Name,
+		Created:  d.now().UTC(),
+		Before:   d.hooks.before,
+		Tables:   make([]fileEntry, 0),
+		After:    d.hooks.after,
+	}
+
+	for d.nav.hasNext() {
+		t, err := d.nav.next()
+		if err != nil {
+			d.logger.Error("msg", "can't fetch next table", "error", err)
+
+			return err
+		}
+
+		columns, err := d.columnTypes(t)
+		if err != nil {
+			return err
+		}
+
+		file := t.TableName + ext
+
+		if err := writeObject(out, "/"+file, func(w io.Writer) error { return fn(ctx, w, t, columns) }); err != nil {
+			d.logger.Error("msg", "failed to write table file", "table", t.TableName, "error", err)
+
+			return err
+		}
+
+		m.Tables = append(m.Tables, fileEntry{
+			Table: t.TableName, File: file, Columns: columns, PreActions: t.PreActions, PostActions: t.afterLoad(),
+		})
+	}
+
+	return writeObject(out, "/"+filesManifestName, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(m)
+	})
+}
+
+// columnTypes returns dumped columns of the table with their types. Columns not found in the table, e.g. computed by
+// the table query, have an empty type.
+func (d *dumper) columnTypes(t *table) ([]database.Column, error) {
+	all, err := d.db.GetTableColumnTypes(t.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]string, len(all))
+	for _, c := range all {
+		types[c.Name] = c.Type
+	}
+
+	columns := make([]database.Column, 0, len(t.Columns))
+	for _, name := range t.Columns {
+		columns = append(columns, database.Column{Name: name, Type: types[name]})
+	}
+
+	return columns, nil
+}
+
+// copySource returns the table with its column list or rendered query as a COPY source.
+func copySource(m *manifest, t *table) (string, error) {
+	if t.Query != "" || t.orderBy != "" || len(t.selectList) > 0 {
+		return copyFrom(m, t)
+	}
+
+	return fmt.Sprintf("%s (%s)", t.TableName, quoteColumns(t.Columns)), nil
+}
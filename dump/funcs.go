@@ -0,0 +1,229 @@
+package dump
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// queryFuncs are the functions available in table queries.
+var queryFuncs = template.FuncMap{
+	"literal":  literal,
+	"ident":    ident,
+	"inList":   inList,
+	"daysAgo":  daysAgo,
+	"now":      currentTime,
+	"join":     join,
+	"default":  defaultValue,
+	"required": required,
+}
+
+// timeVar is a date or time variable, printed in ISO 8601 format, dates without a time of day.
+type timeVar struct {
+	time.Time
+}
+
+func (t timeVar) String() string {
+	if h, m, s := t.Clock(); t.Location() == time.UTC && h == 0 && m == 0 && s == 0 && t.Nanosecond() == 0 {
+		return t.Format("2006-01-02")
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+// literal returns the value as a SQL literal, lists are returned as arrays.
+func literal(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "NULL", nil
+	case string:
+		return quoteLiteral(v), nil
+	case bool:
+		if v {
+			return "TRUE", nil
+		}
+
+		return "FALSE", nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return quoteLiteral(strconv.FormatFloat(v, 'g', -1, 64)), nil
+		}
+
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case timeVar:
+		return quoteLiteral(v.String()), nil
+	case time.Time:
+		return quoteLiteral(timeVar{v}.String()), nil
+	case []interface{}:
+		items, err := literals(v)
+		if err != nil {
+			return "", err
+		}
+
+		if len(items) == 0 {
+			return "'{}'", nil
+		}
+
+		return "ARRAY[" + strings.Join(items, ", ") + "]", nil
+	default:
+		return "", errors.Errorf("unsupported literal of type %T", v)
+	}
+}
+
+// quoteLiteral quotes the string as a SQL literal, strings with backslashes are written as escape strings to read them
+// the same regardless of standard_conforming_strings.
+func quoteLiteral(s string) string {
+	quoted := "'" + strings.ReplaceAll(s, "'", "''") + "'"
+
+	if strings.Contains(s, `\`) {
+		return "E" + strings.ReplaceAll(quoted, `\`, `\\`)
+	}
+
+	return quoted
+}
+
+// ident returns the names quoted as SQL identifiers joined with dots, e.g. ident "public" "users".
+func ident(names ...string) (string, error) {
+	if len(names) == 0 {
+		return "", errors.New("ident requires a name")
+	}
+
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, quoteIdent(name))
+	}
+
+	return strings.Join(quoted, "."), nil
+}
+
+// inList returns the list as a parenthesized list of SQL literals for IN conditions. Strings are comma separated
+// lists, e.g. given by --var. An empty list is (NULL), matching no rows.
+func inList(v interface{}) (string, error) {
+	items, err := literals(list(v))
+	if err != nil {
+		return "", err
+	}
+
+	if len(items) == 0 {
+		return "(NULL)", nil
+	}
+
+	return "(" + strings.Join(items, ", ") + ")", nil
+}
+
+// daysAgo returns the current time n days ago.
+func daysAgo(n interface{}) (timeVar, error) {
+	days, err := toInt(n)
+	if err != nil {
+		return timeVar{}, err
+	}
+
+	return timeVar{time.Now().UTC().AddDate(0, 0, -days)}, nil
+}
+
+// currentTime returns the current time.
+func currentTime() timeVar {
+	return timeVar{time.Now().UTC()}
+}
+
+// join joins the items of the list with the separator, without quoting them.
+func join(sep string, v interface{}) string {
+	items := make([]string, 0)
+	for _, item := range list(v) {
+		items = append(items, fmt.Sprint(item))
+	}
+
+	return strings.Join(items, sep)
+}
+
+// defaultValue returns the value, or the default if the value is empty.
+func defaultValue(def, v interface{}) interface{} {
+	if isEmpty(v) {
+		return def
+	}
+
+	return v
+}
+
+// required returns the value, or fails with the message if the value is empty.
+func required(msg string, v interface{}) (interface{}, error) {
+	if isEmpty(v) {
+		return nil, errors.New(msg)
+	}
+
+	return v, nil
+}
+
+// literals returns SQL literals of the items.
+func literals(items []interface{}) ([]string, error) {
+	quoted := make([]string, 0, len(items))
+
+	for _, item := range items {
+		l, err := literal(item)
+		if err != nil {
+			return nil, err
+		}
+
+		quoted = append(quoted, l)
+	}
+
+	return quoted, nil
+}
+
+// list returns the items of a list value, strings are split by commas.
+func list(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	case string:
+		items := make([]interface{}, 0)
+
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+
+		return items
+	default:
+		return []interface{}{v}
+	}
+}
+
+func toInt(v interface{}) (int, error) {
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case string:
+		return strconv.Atoi(strings.TrimSpace(v))
+	default:
+		return 0, errors.Errorf("expected an integer, got %T", v)
+	}
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return rv.Len() == 0
+	default:
+		return false
+	}
+}
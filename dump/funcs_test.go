@@ -0,0 +1,85 @@
+package dump
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := map[string]string{
+		"":              "''",
+		"alice":         "'alice'",
+		"o'brien":       "'o''brien'",
+		`C:\temp`:       `E'C:\\temp'`,
+		`it's a \ path`: `E'it''s a \\ path'`,
+		"line\nbreak":   "'line\nbreak'",
+	}
+
+	for s, want := range tests {
+		if got := quoteLiteral(s); got != want {
+			t.Errorf("quoteLiteral(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{nil, "NULL"},
+		{true, "TRUE"},
+		{42, "42"},
+		{int64(-7), "-7"},
+		{1.5, "1.5"},
+		{math.Inf(-1), "'-Inf'"},
+		{time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), "'2024-02-29'"},
+		{[]interface{}{"a", 1}, "ARRAY['a', 1]"},
+		{[]interface{}{}, "'{}'"},
+	}
+
+	for _, test := range tests {
+		if got, err := literal(test.value); err != nil || got != test.want {
+			t.Errorf("literal(%#v) = %s, %v, want %s", test.value, got, err, test.want)
+		}
+	}
+
+	if _, err := literal(struct{}{}); err == nil {
+		t.Error("unsupported value accepted")
+	}
+}
+
+func TestInList(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"a, b ,,c", "('a', 'b', 'c')"},
+		{"", "(NULL)"},
+		{nil, "(NULL)"},
+		{[]interface{}{1, "o'brien"}, "(1, 'o''brien')"},
+		{[]interface{}{}, "(NULL)"},
+		{5, "(5)"},
+	}
+
+	for _, test := range tests {
+		if got, err := inList(test.value); err != nil || got != test.want {
+			t.Errorf("inList(%#v) = %s, %v, want %s", test.value, got, err, test.want)
+		}
+	}
+
+	if _, err := inList([]interface{}{struct{}{}}); err == nil {
+		t.Error("unsupported item accepted")
+	}
+}
+
+func TestIdent(t *testing.T) {
+	if got, err := ident("public", `my "table"`); err != nil || got != `"public"."my ""table"""` {
+		t.Errorf("unexpected identifier %s, %v", got, err)
+	}
+
+	if _, err := ident(); err == nil {
+		t.Error("identifier without a name accepted")
+	}
+}
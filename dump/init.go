@@ -0,0 +1,160 @@
+package dump
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// default values of generated manifests.
+const (
+	// LargeTableRows is the estimated row count from which a limit is suggested for a table.
+	LargeTableRows = 100000
+	// SuggestedLimit is the row limit suggested for large tables.
+	SuggestedLimit = 10000
+)
+
+// sensitiveColumn matches column names likely to contain personal or secret data.
+var sensitiveColumn = regexp.MustCompile(`(^|_)(e?mail|phone|mobile|fax|password|passwd|pwd|secret|token|api_?key|` +
+	`ssn|social_security|tax_?id|passport|national_id|iban|card_number|credit_card|cvv|birth|birthday|birthdate|dob|` +
+	`address|street|zip|postal|postcode|first_?name|last_?name|full_?name|surname|ip|ip_address|salary|gender)($|_)`)
+
+// GenerateManifest introspects the database and writes a commented starter manifest of all its tables to w. Tables
+// are listed in dependency order. Comments show row estimates, referenced tables, sequences, suggested limits for large
+// tables and TODO markers for columns likely to be sensitive.
+func GenerateManifest(logger log.Logger, db database.DB, w io.Writer) error {
+	info, err := db.GetInfo()
+	if err != nil {
+		return err
+	}
+
+	tables, err := db.GetTables()
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]database.Table, len(tables))
+	m := &manifest{Tables: make([]table, 0, len(tables))}
+
+	for _, t := range tables {
+		byName[t.Name] = t
+		m.Tables = append(m.Tables, table{TableName: t.Name})
+	}
+
+	items := &yaml.Node{Kind: yaml.SequenceNode}
+
+	for nav := newNavigator(logger, db, m, false); nav.hasNext(); {
+		t, err := nav.next()
+		if err != nil {
+			return err
+		}
+
+		item, err := tableNode(db, t, byName[t.TableName])
+		if err != nil {
+			return err
+		}
+
+		items.Content = append(items.Content, item)
+	}
+
+	version := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentManifestVersion)}
+
+	key := strNode("manifest_version")
+	key.HeadComment = fmt.Sprintf("Generated by pdd init from database %s on %s.\n"+
+		"Review the TODO markers and the suggested limits before dumping.", info.Name, time.Now().UTC().Format("2006-01-02"))
+
+	tablesKey := strNode("tables")
+	tablesKey.HeadComment = "Tables in dependency order, referenced tables come first."
+
+	root := &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{key, version, tablesKey, items}}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(&yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}); err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+
+	logger.Debug("msg", "generate manifest", "tables", len(items.Content))
+
+	return enc.Close()
+}
+
+// tableNode returns the manifest entry of the table, commented with its introspection results.
+func tableNode(db database.DB, t *table, info database.Table) (*yaml.Node, error) {
+	columns, err := db.GetTableDefinition(t.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	comments := make([]string, 0)
+	sensitive := make([]string, 0)
+
+	for _, c := range columns {
+		if sensitiveColumn.MatchString(strings.ToLower(c.Name)) {
+			sensitive = append(sensitive, c.Name)
+		}
+	}
+
+	if info.EstimatedRows < 0 {
+		comments = append(comments, "rows: unknown, the table isn't analyzed yet")
+	} else {
+		comments = append(comments, "rows: ~"+groupDigits(info.EstimatedRows))
+	}
+
+	if len(t.dependencies) > 0 {
+		comments = append(comments, "references: "+strings.Join(t.dependencies, ", "))
+	}
+
+	if len(t.sequences) > 0 {
+		names := make([]string, 0, len(t.sequences))
+		for _, seq := range t.sequences {
+			names = append(names, seq.Name)
+		}
+
+		comments = append(comments, "sequences: "+strings.Join(names, ", ")+", reset after loading")
+	}
+
+	if info.EstimatedRows >= LargeTableRows {
+		comments = append(comments, "TODO: large table, consider limiting the rows, e.g.",
+			fmt.Sprintf("  query: SELECT * FROM %s LIMIT %d", t.TableName, SuggestedLimit))
+	}
+
+	if len(sensitive) > 0 {
+		comments = append(comments, "TODO: columns may contain sensitive data, exclude them with exclude_columns or "+
+			"mask them with set: "+strings.Join(sensitive, ", "))
+	}
+
+	item := &yaml.Node{Kind: yaml.MappingNode, HeadComment: strings.Join(comments, "\n")}
+	item.Content = append(item.Content, strNode("table"), strNode(t.TableName))
+
+	// partitioned tables can't be copied directly, only through a query of their rows
+	if info.Partitioned {
+		item.Content = append(item.Content, strNode("query"), strNode("SELECT * FROM "+t.TableName))
+	}
+
+	return item, nil
+}
+
+// groupDigits formats the number with comma separated thousands.
+func groupDigits(n int64) string {
+	s := strconv.FormatInt(n, 10)
+
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+
+	return s
+}
+
+func strNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
@@ -0,0 +1,35 @@
+package dump
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateManifestCycle(t *testing.T) {
+	db := navigatorTestDB(map[string][]string{
+		"accounts": {"users"},
+		"users":    {"accounts"},
+		"logs":     nil,
+	})
+
+	var out bytes.Buffer
+
+	if err := GenerateManifest(newTestLogger(t), db, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	m := manifest{}
+	if err := decodeManifest("generated", out.Bytes(), &m); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+
+	names := make([]string, 0, len(m.Tables))
+	for _, table := range m.Tables {
+		names = append(names, table.TableName)
+	}
+
+	if got := strings.Join(names, " "); got != "users accounts logs" {
+		t.Fatalf("unexpected tables %q\n%s", got, out.String())
+	}
+}
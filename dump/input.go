@@ -0,0 +1,11 @@
+package dump
+
+import (
+	"io"
+)
+
+// Input is the source of a restore, objects are named by suffixes like the ones written to Output.
+type Input interface {
+	// Open opens the object with the given suffix.
+	Open(suffix string) (io.ReadCloser, error)
+}
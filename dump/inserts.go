@@ -0,0 +1,192 @@
+package dump
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+const insertsTableHeader = `
+--
+-- Data for Name: %s; Type: TABLE DATA
+--
+
+`
+
+// dumpInserts dumps the database as a plain SQL script loading data with INSERT statements.
+func (d *dumper) dumpInserts(ctx context.Context, w io.Writer) error {
+	if _, err := fmt.Fprint(w, dumpHeader, dumpSettings); err != nil {
+		d.logger.Error("msg", "failed to write dump header", "error", err)
+
+		return err
+	}
+
+	if err := writeActions(w, d.hooks.before); err != nil {
+		d.logger.Error("msg", "failed to write before statements", "error", err)
+
+		return err
+	}
+
+	for d.nav.hasNext() {
+		t, err := d.nav.next()
+		if err != nil {
+			d.logger.Error("msg", "can't fetch next table", "error", err)
+
+			return err
+		}
+
+		markBoundary(w)
+
+		if err := writeActions(w, t.PreActions); err != nil {
+			d.logger.Error("msg", "failed to write table pre actions", "table", t.TableName, "error", err)
+
+			return err
+		}
+
+		if _, err := fmt.Fprintf(w, insertsTableHeader, t.TableName); err != nil {
+			d.logger.Error("msg", "failed to write table header", "error", err)
+
+			return err
+		}
+
+		if err := d.writeInserts(ctx, w, t); err != nil {
+			d.logger.Error("msg", "failed to write table data", "table", t.TableName, "error", err)
+
+			return err
+		}
+
+		if err := writeActions(w, t.afterLoad()); err != nil {
+			d.logger.Error("msg", "failed to write table post actions", "table", t.TableName, "error", err)
+
+			return err
+		}
+	}
+
+	if err := writeActions(w, d.hooks.after); err != nil {
+		d.logger.Error("msg", "failed to write after statements", "error", err)
+
+		return err
+	}
+
+	if _, err := fmt.Fprint(w, dumpFooter); err != nil {
+		d.logger.Error("msg", "failed to write dump footer", "error", err)
+
+		return err
+	}
+
+	return nil
+}
+
+// writeInserts writes table rows as INSERT statements of up to RowsPerInsert rows. Values are quoted by the server,
+// so every type gets its own literal syntax.
+func (d *dumper) writeInserts(ctx context.Context, w io.Writer, t *table) error {
+	columns, err := d.columnTypes(t)
+	if err != nil {
+		return err
+	}
+
+	all, err := d.db.GetTableColumns(t.TableName)
+	if err != nil {
+		return err
+	}
+
+	source, err := copyFrom(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	// values without column list must match all columns of the table
+	prefix := fmt.Sprintf("INSERT INTO %s", t.TableName)
+	if d.cfg.ColumnInserts || strings.Join(all, ",") != strings.Join(t.Columns, ",") {
+		prefix = fmt.Sprintf("%s (%s)", prefix, quoteColumns(t.Columns))
+	}
+
+	// values of GENERATED ALWAYS identity columns are rejected unless overriding, COPY loads them as they are
+	if t.overriding {
+		prefix += " OVERRIDING SYSTEM VALUE"
+	}
+
+	suffix := ";\n"
+	if d.cfg.OnConflictDoNothing {
+		suffix = " ON CONFLICT DO NOTHING;\n"
+	}
+
+	bw := bufio.NewWriter(w)
+	rows := 0
+
+	err = d.scanCopyRows(ctx, insertQuery(columns, source, t.orderBy), func(value []byte) error {
+		if d.cfg.RowsPerInsert == 1 {
+			_, err := fmt.Fprintf(bw, "%s VALUES %s%s", prefix, value, suffix)
+
+			return err
+		}
+
+		sep := ",\n\t"
+		if rows == 0 {
+			sep = prefix + " VALUES\n\t"
+		}
+
+		if _, err := fmt.Fprintf(bw, "%s%s", sep, value); err != nil {
+			return err
+		}
+
+		if rows++; rows < d.cfg.RowsPerInsert {
+			return nil
+		}
+
+		rows = 0
+
+		_, err := bw.WriteString(suffix)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the last statement is not complete unless the batch is full
+	if rows > 0 {
+		if _, err := bw.WriteString(suffix); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// insertQuery returns the query selecting rows of the source as VALUES tuples, optionally ordered by the given columns.
+func insertQuery(columns []database.Column, source, orderBy string) string {
+	exprs := make([]string, 0, len(columns))
+
+	for _, c := range columns {
+		exprs = append(exprs, insertValue("s."+quoteIdent(c.Name), c.Type))
+	}
+
+	if orderBy != "" {
+		orderBy = " ORDER BY " + orderBy
+	}
+
+	return fmt.Sprintf("(SELECT '(' || concat_ws(', ', %s) || ')' FROM %s AS s%s)", strings.Join(exprs, ", "), source,
+		orderBy)
+}
+
+// insertValue returns the expression formatting the column as a literal. Numbers and booleans are written unquoted,
+// everything else is quoted as a string literal and converted to the column type by the server.
+func insertValue(col, typ string) string {
+	switch baseType(typ) {
+	case "smallint", "integer", "bigint", "oid", "boolean":
+		return fmt.Sprintf("COALESCE(%s::text, 'NULL')", col)
+	case "real", "double precision", "numeric":
+		// special values are not numeric literals
+		return fmt.Sprintf(
+			"CASE WHEN %[1]s IS NULL THEN 'NULL' WHEN %[1]s::text IN ('NaN', 'Infinity', '-Infinity') "+
+				"THEN quote_literal(%[1]s::text) ELSE %[1]s::text END", col,
+		)
+	default:
+		return fmt.Sprintf("quote_nullable(%s)", col)
+	}
+}
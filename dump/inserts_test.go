@@ -0,0 +1,38 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+func TestInsertValue(t *testing.T) {
+	tests := map[string]string{
+		"integer":  `COALESCE(c::text, 'NULL')`,
+		"boolean":  `COALESCE(c::text, 'NULL')`,
+		"bigint[]": `quote_nullable(c)`,
+		"numeric(10,2)": `CASE WHEN c IS NULL THEN 'NULL' WHEN c::text IN ('NaN', 'Infinity', '-Infinity') ` +
+			`THEN quote_literal(c::text) ELSE c::text END`,
+		"double precision": `CASE WHEN c IS NULL THEN 'NULL' WHEN c::text IN ('NaN', 'Infinity', '-Infinity') ` +
+			`THEN quote_literal(c::text) ELSE c::text END`,
+		"text":                     `quote_nullable(c)`,
+		"timestamp with time zone": `quote_nullable(c)`,
+	}
+
+	for typ, want := range tests {
+		if got := insertValue("c", typ); got != want {
+			t.Errorf("insertValue of %s = %s, want %s", typ, got, want)
+		}
+	}
+}
+
+func TestInsertQuery(t *testing.T) {
+	columns := []database.Column{{Name: "id", Type: "integer"}, {Name: `say "hi"`, Type: "text"}}
+
+	want := `(SELECT '(' || concat_ws(', ', COALESCE(s."id"::text, 'NULL'), quote_nullable(s."say ""hi""")) || ')' ` +
+		`FROM users AS s ORDER BY s."id")`
+
+	if got := insertQuery(columns, "users", `s."id"`); got != want {
+		t.Fatalf("unexpected query\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/pkg/errors"
+)
+
+// timestamp format producing RFC 3339 timestamps in UTC.
+const jsonTimestampFormat = `'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'`
+
+// writeJSONL writes table data as JSON Lines, one JSON object per row.
+func (d *dumper) writeJSONL(ctx context.Context, w io.Writer, t *table, columns []database.Column) error {
+	source, err := copyFrom(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	return d.db.CopyTo(ctx, newCopyTextDecoder(w), jsonQuery(columns, source, t.orderBy))
+}
+
+// jsonQuery returns the query selecting rows of the source as JSON objects, optionally ordered by the given columns.
+func jsonQuery(columns []database.Column, source, orderBy string) string {
+	if orderBy != "" {
+		orderBy = " ORDER BY " + orderBy
+	}
+
+	return fmt.Sprintf("(SELECT row_to_json(r) FROM (SELECT %s FROM %s AS s%s) r)", jsonColumns(columns), source, orderBy)
+}
+
+// scanJSONRows runs the JSON query and calls fn with the values of each row by column name.
+func (d *dumper) scanJSONRows(ctx context.Context, query string, fn func(values map[string]json.RawMessage) error) error {
+	return d.scanCopyRows(ctx, query, func(value []byte) error {
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(value, &values); err != nil {
+			return errors.Wrap(err, "failed to decode row")
+		}
+
+		return fn(values)
+	})
+}
+
+// jsonColumns returns the select list converting columns to their JSON representations.
+func jsonColumns(columns []database.Column) string {
+	exprs := make([]string, 0, len(columns))
+
+	for _, c := range columns {
+		name := quoteIdent(c.Name)
+		exprs = append(exprs, fmt.Sprintf("%s AS %s", jsonValue("s."+name, c.Type), name))
+	}
+
+	return strings.Join(exprs, ", ")
+}
+
+// jsonValue returns the expression converting the column to a value with the desired JSON representation. Values
+// without a conversion are converted by row_to_json, e.g. numbers, booleans, arrays and strings.
+func jsonValue(col, typ string) string {
+	// arrays are kept as native JSON arrays
+	if strings.HasSuffix(typ, "[]") {
+		return col
+	}
+
+	switch baseType(typ) {
+	case "numeric", "money":
+		// keep precision, JSON numbers are usually parsed as doubles
+		return col + "::text"
+	case "timestamp with time zone":
+		return jsonTimestamp(fmt.Sprintf("(%s AT TIME ZONE 'UTC')", col), col)
+	case "timestamp without time zone":
+		// timestamps without time zone are assumed to be in UTC
+		return jsonTimestamp(col, col)
+	case "bytea":
+		return fmt.Sprintf("translate(encode(%s, 'base64'), E'\\n', '')", col)
+	case "json":
+		// normalizes the value, json may contain line breaks
+		return col + "::jsonb"
+	default:
+		return col
+	}
+}
+
+// jsonTimestamp formats finite timestamps as RFC 3339, infinite ones are kept as text.
+func jsonTimestamp(utc, col string) string {
+	return fmt.Sprintf("CASE WHEN isfinite(%s) THEN to_char(%s, %s) ELSE %s::text END", col, utc, jsonTimestampFormat, col)
+}
+
+// baseType strips type modifiers, e.g. numeric(10,2) or timestamp(3) with time zone.
+func baseType(typ string) string {
+	if i := strings.Index(typ, "("); i >= 0 {
+		if j := strings.Index(typ[i:], ")"); j >= 0 {
+			return typ[:i] + typ[i+j+1:]
+		}
+	}
+
+	return typ
+}
@@ -0,0 +1,35 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+func TestJSONQuery(t *testing.T) {
+	columns := []database.Column{{Name: "id", Type: "integer"}, {Name: `say "hi"`, Type: "text"}}
+
+	want := `(SELECT row_to_json(r) FROM (SELECT s."id" AS "id", s."say ""hi""" AS "say ""hi""" FROM users AS s ` +
+		`ORDER BY s."id") r)`
+
+	if got := jsonQuery(columns, "users", `s."id"`); got != want {
+		t.Fatalf("unexpected query\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDumpJSONLDeterministic(t *testing.T) {
+	columns := []database.Column{{Name: "id", Type: "integer"}}
+	source := `(SELECT "id" FROM users AS s ORDER BY ROW(s.*)::text COLLATE "C")`
+
+	// the outer query keeps the order of the rows
+	db := &catalogDB{fakeDB: fakeDB{
+		tables: map[string]fakeTable{"users": {columns: []database.ColumnDefinition{{Name: "id", Type: "integer"}}}},
+		copies: map[string]string{jsonQuery(columns, source, `ROW(s.*)::text COLLATE "C"`): "{\"id\":1}\n"},
+	}}
+
+	out := testDump(t, db, "tables:\n  - table: users", Config{Format: FormatJSONL, Deterministic: true})
+
+	if got := out.objects["/users.jsonl"].String(); got != "{\"id\":1}\n" {
+		t.Fatalf("unexpected rows %q, objects %v", got, out.suffixes())
+	}
+}
@@ -3,33 +3,135 @@ package dump
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/aweris/postgres-data-dump/database"
 	"github.com/aweris/postgres-data-dump/internal/log"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
-// manifest contains configuration describing how to export the database.
+// manifest contains configuration describing how to export the database. Fields are described with desc tags for
+// the JSON Schema of manifests.
 type manifest struct {
-	Vars   map[string]string `yaml:"vars"`
-	Tables []table           `yaml:"tables"`
+	ManifestVersion int `yaml:"manifest_version,omitempty" desc:"Version of the manifest format, the current version if not given"`
+
+	Extends string   `yaml:"extends,omitempty" desc:"Path of a base manifest, relative to the manifest file unless absolute"`
+	Include []string `yaml:"include,omitempty" desc:"Manifest files or glob patterns merged in order, relative to the manifest file unless absolute"`
+
+	Vars          map[string]interface{} `yaml:"vars,omitempty" desc:"Variables of query templates"`
+	VarsFromQuery map[string]varQuery    `yaml:"vars_from_query,omitempty" desc:"Variables set to the results of queries, evaluated once per dump"`
+	Selections    []selection            `yaml:"selections,omitempty" desc:"Row sets materialized into temporary tables, used in queries with sel"`
+	Before        []string               `yaml:"before,omitempty" desc:"Statement templates executed before loading the tables"`
+	Tables        []table                `yaml:"tables" desc:"Tables to dump, referenced tables are dumped first"`
+	After         []string               `yaml:"after,omitempty" desc:"Statement templates executed after loading the tables"`
+
+	// selected holds the names of materialized selections
+	selected map[string]bool
+
+	// varDirs holds the directories of the manifest files defining the variables, relative files of variables are
+	// relative to them.
+	varDirs map[string]string
+
+	// source is the manifest file content, or the resolved manifest of manifests with extends or includes.
+	source []byte
 }
 
 // table contains table configuration for the export.
 type table struct {
-	TableName   string   `yaml:"table"`
-	Query       string   `yaml:"query"`
-	Columns     []string `yaml:"columns,flow"`
-	PostActions []string `yaml:"post_actions,flow"`
+	TableName      string                 `yaml:"table" required:"true" desc:"Name of the table"`
+	Query          string                 `yaml:"query,omitempty" desc:"Query template selecting the rows to dump"`
+	Columns        []string               `yaml:"columns,flow,omitempty" desc:"Columns to dump, all columns if not given"`
+	ExcludeColumns []string               `yaml:"exclude_columns,flow,omitempty" desc:"Columns not to dump, loaded with their defaults"`
+	Set            map[string]interface{} `yaml:"set,omitempty" desc:"Constant values of columns, dumped instead of their values"`
+	PreActions     []string               `yaml:"pre_actions,flow,omitempty" desc:"Statement templates executed before loading the table"`
+	PostActions    []string               `yaml:"post_actions,flow,omitempty" desc:"Statement templates executed after loading the table"`
+
+	// dependencies are the tables referenced by the table, resolved by navigator.
+	dependencies []string
+	// orderBy orders the rows of the table over its source aliased as s, resolved by navigator for ordered dumps.
+	orderBy string
+	// selectList selects the dumped columns over the source aliased as s, resolved by navigator for tables with
+	// excluded, set or generated columns.
+	selectList []string
+	// sequences are the sequences owned by the dumped columns, resolved by navigator.
+	sequences []database.Sequence
+	// resets are the statements resetting the sequences after loading the table, in the order of sequences.
+	resets []string
+	// overriding loads the table overriding the values of GENERATED ALWAYS identity columns, resolved by navigator.
+	overriding bool
+}
+
+// varQuery is a query evaluated to a variable.
+type varQuery struct {
+	Query string `yaml:"query" required:"true" desc:"Query template returning the value in the first column"`
+	List  bool   `yaml:"list,omitempty" desc:"Set the variable to the values of all rows instead of a single value"`
+}
+
+// UnmarshalYAML decodes a varQuery, a string is the query of a single value.
+func (q *varQuery) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		q.Query = value.Value
+
+		return nil
+	}
+
+	type plain varQuery
+
+	return value.Decode((*plain)(q))
+}
+
+// loadManifest creates new manifest instance from given file, resolving its extends and includes. Variables of the
+// manifest are overridden by the given ones, then their values are resolved.
+func loadManifest(logger log.Logger, manifestFile string, vars map[string]string) (*manifest, error) {
+	l := manifestLoader{logger: logger, loaded: make(map[string]bool)}
+
+	m, err := l.load(manifestFile, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// source keeps the variables as written, resolved values may be secrets
+	if m.Vars, err = resolveVars(m.Vars, vars, m.varDirs); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// manifestLoader loads manifest files composed with extends and includes.
+type manifestLoader struct {
+	logger log.Logger
+
+	// loaded holds the absolute paths of loaded files, a file is merged only once
+	loaded map[string]bool
 }
 
-// loadManifest creates new manifest instance from given file.
-func loadManifest(logger log.Logger, manifestFile string) (*manifest, error) {
+// load loads the manifest file, stack holds the absolute paths of the files extending or including it.
+func (l *manifestLoader) load(manifestFile string, stack []string) (*manifest, error) {
+	path, err := filepath.Abs(manifestFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve manifest file")
+	}
+
+	for i, f := range stack {
+		if f == path {
+			return nil, errors.Wrap(ErrManifestCycle, strings.Join(append(stack[i:], path), " -> "))
+		}
+	}
+
+	// files extended or included more than once are merged at their first occurrence
+	if l.loaded[path] {
+		return &manifest{}, nil
+	}
+
 	// Open manifest file
 	file, err := os.Open(manifestFile)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to open manifest file")
 	}
+	defer file.Close()
 
 	// Read manifest file
 	data, err := ioutil.ReadAll(file)
@@ -37,15 +139,164 @@ func loadManifest(logger log.Logger, manifestFile string) (*manifest, error) {
 		return nil, errors.Wrap(err, "failed to read manifest file")
 	}
 
-	// Unmarshal manifest
-	manifest := manifest{}
+	// Decode manifest
+	m := manifest{}
 
-	err = yaml.Unmarshal(data, &manifest)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal manifest file")
+	if err := decodeManifest(manifestFile, data, &m); err != nil {
+		return nil, err
+	}
+
+	l.loaded[path] = true
+
+	l.logger.Debug("msg", "load manifest file", "file", manifestFile)
+
+	dir := filepath.Dir(manifestFile)
+
+	m.varDirs = make(map[string]string, len(m.Vars))
+	for k := range m.Vars {
+		m.varDirs[k] = dir
 	}
 
-	logger.Debug("msg", "load manifest file", "file", manifestFile)
+	if m.Extends == "" && len(m.Include) == 0 {
+		m.source = data
+
+		return &m, nil
+	}
+
+	stack = append(stack, path)
+
+	// base manifest first, then includes in order and the manifest itself last
+	merged := manifest{
+		ManifestVersion: CurrentManifestVersion,
+		Vars:            make(map[string]interface{}),
+		varDirs:         make(map[string]string),
+	}
+
+	if m.Extends != "" {
+		base, err := l.load(resolvePath(dir, m.Extends), stack)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to extend %s", manifestFile)
+		}
+
+		merged.merge(base)
+	}
+
+	for _, pattern := range m.Include {
+		files, err := filepath.Glob(resolvePath(dir, pattern))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid include pattern %q", pattern)
+		}
+
+		if len(files) == 0 {
+			return nil, errors.Wrapf(ErrNoManifestMatch, "%s includes %q", manifestFile, pattern)
+		}
+
+		for _, f := range files {
+			included, err := l.load(f, stack)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to include %s", f)
+			}
+
+			merged.merge(included)
+		}
+	}
+
+	m.Extends, m.Include = "", nil
+	merged.merge(&m)
+
+	// keeps the resolved manifest as source
+	if merged.source, err = yaml.Marshal(&merged); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal manifest")
+	}
+
+	return &merged, nil
+}
+
+// resolvePath returns the path relative to the directory, absolute paths are returned as they are.
+func resolvePath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(dir, p)
+}
+
+// merge merges the other manifest into the manifest. Variables, variable queries and selections of the other manifest
+// override the existing ones, new selections are appended, before and after statements are appended.
+// Tables not in the manifest are appended, for existing tables a query, columns or excluded columns override the
+// existing ones, set values override the existing values of the same columns and pre and post actions are appended.
+func (m *manifest) merge(other *manifest) {
+	for k, v := range other.Vars {
+		m.Vars[k] = v
+		m.varDirs[k] = other.varDirs[k]
+	}
+
+	if len(other.VarsFromQuery) > 0 && m.VarsFromQuery == nil {
+		m.VarsFromQuery = make(map[string]varQuery)
+	}
+
+	for k, v := range other.VarsFromQuery {
+		m.VarsFromQuery[k] = v
+	}
+
+	for _, sel := range other.Selections {
+		if i := m.selectionIndex(sel.Name); i >= 0 {
+			m.Selections[i] = sel
+		} else {
+			m.Selections = append(m.Selections, sel)
+		}
+	}
+
+	m.Before = append(append([]string(nil), m.Before...), other.Before...)
+	m.After = append(append([]string(nil), m.After...), other.After...)
+
+	for _, t := range other.Tables {
+		i := m.tableIndex(t.TableName)
+		if i < 0 {
+			m.Tables = append(m.Tables, t)
+
+			continue
+		}
+
+		existing := &m.Tables[i]
+
+		if t.Query != "" {
+			existing.Query = t.Query
+		}
+
+		if len(t.Columns) > 0 {
+			existing.Columns = t.Columns
+		}
+
+		if len(t.ExcludeColumns) > 0 {
+			existing.ExcludeColumns = t.ExcludeColumns
+		}
+
+		if len(t.Set) > 0 {
+			set := make(map[string]interface{}, len(existing.Set)+len(t.Set))
+			for k, v := range existing.Set {
+				set[k] = v
+			}
+
+			for k, v := range t.Set {
+				set[k] = v
+			}
+
+			existing.Set = set
+		}
+
+		existing.PreActions = append(append([]string(nil), existing.PreActions...), t.PreActions...)
+		existing.PostActions = append(append([]string(nil), existing.PostActions...), t.PostActions...)
+	}
+}
+
+// tableIndex returns the index of the table entry, or -1 if the manifest has no entry for the table.
+func (m *manifest) tableIndex(name string) int {
+	for i, t := range m.Tables {
+		if t.TableName == name {
+			return i
+		}
+	}
 
-	return &manifest, nil
+	return -1
 }
@@ -0,0 +1,159 @@
+package dump
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeManifests writes the manifest files into the directory, names are relative to it.
+func writeManifests(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(strings.TrimSpace(content)+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadManifestPaths(t *testing.T) {
+	dir, shared := t.TempDir(), t.TempDir()
+
+	writeManifests(t, shared, map[string]string{
+		"base.yaml":         "before: [SELECT 'base']\ntables: []",
+		"parts/one.yaml":    "before: [SELECT 'one']\ntables: []",
+		"parts/two.yaml":    "before: [SELECT 'two']\ntables: []",
+		"parts/ignored.yml": "before: [SELECT 'ignored']\ntables: []",
+	})
+
+	writeManifests(t, dir, map[string]string{
+		"local.yaml": "before: [SELECT 'local']\ntables: []",
+		".pdd.yaml": `
+extends: ` + filepath.Join(shared, "base.yaml") + `
+include:
+  - ` + filepath.Join(shared, "parts", "*.yaml") + `
+  - local.yaml
+before:
+  - SELECT 'self'
+tables: []
+`,
+	})
+
+	m, err := loadManifest(newTestLogger(t), filepath.Join(dir, ".pdd.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"SELECT 'base'", "SELECT 'one'", "SELECT 'two'", "SELECT 'local'", "SELECT 'self'"}
+	if !reflect.DeepEqual(m.Before, want) {
+		t.Fatalf("unexpected before statements %q, want %q", m.Before, want)
+	}
+}
+
+func TestLoadManifestIncludeError(t *testing.T) {
+	dir := t.TempDir()
+
+	writeManifests(t, dir, map[string]string{
+		".pdd.yaml":   "include: [broken.yaml]\ntables: []",
+		"broken.yaml": "tables: {",
+	})
+
+	_, err := loadManifest(newTestLogger(t), filepath.Join(dir, ".pdd.yaml"), nil)
+	if err == nil {
+		t.Fatal("broken include loaded")
+	}
+
+	if want := "failed to include " + filepath.Join(dir, "broken.yaml"); !strings.Contains(err.Error(), want) {
+		t.Fatalf("error %q doesn't contain %q", err, want)
+	}
+}
+
+func TestLoadManifestVarFiles(t *testing.T) {
+	dir, wd := t.TempDir(), t.TempDir()
+
+	writeManifests(t, dir, map[string]string{
+		"secrets/key":  "manifest\n",
+		"absolute.txt": "absolute",
+		".pdd.yaml": `
+vars:
+  key: "file:secrets/key"
+  abs: "file:` + filepath.Join(dir, "absolute.txt") + `"
+tables: []
+`,
+	})
+
+	writeManifests(t, wd, map[string]string{"secrets/key": "override"})
+
+	m, err := loadManifest(newTestLogger(t), filepath.Join(dir, ".pdd.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Vars["key"] != "manifest" || m.Vars["abs"] != "absolute" {
+		t.Fatalf("unexpected variables %v", m.Vars)
+	}
+
+	// files of overrides are relative to the working directory
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(wd); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		if err := os.Chdir(cwd); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	m, err = loadManifest(newTestLogger(t), filepath.Join(dir, ".pdd.yaml"), map[string]string{"key": "file:secrets/key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Vars["key"] != "override" {
+		t.Fatalf("unexpected override %v", m.Vars["key"])
+	}
+}
+
+func TestLoadManifestIncludedVarFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	writeManifests(t, dir, map[string]string{
+		"key":             "root",
+		"base/key":        "base",
+		"parts/key":       "part",
+		"base/base.yaml":  "vars:\n  base: \"file:key\"\n  overridden: \"file:key\"\ntables: []\n",
+		"parts/vars.yaml": "vars:\n  part: \"file:key\"\ntables: []\n",
+		".pdd.yaml": `
+extends: base/base.yaml
+include: [parts/*.yaml]
+vars:
+  root: "file:key"
+  overridden: "file:key"
+tables: []
+`,
+	})
+
+	m, err := loadManifest(newTestLogger(t), filepath.Join(dir, ".pdd.yaml"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// files are relative to the manifest file defining the variable
+	want := map[string]interface{}{"root": "root", "base": "base", "part": "part", "overridden": "root"}
+	if !reflect.DeepEqual(m.Vars, want) {
+		t.Fatalf("unexpected variables %v, want %v", m.Vars, want)
+	}
+}
@@ -1,8 +1,11 @@
 package dump
 
 import (
+	"strings"
+
 	"github.com/aweris/postgres-data-dump/database"
 	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
 )
 
 // navigator is a simple iterator for navigating tables.
@@ -13,10 +16,16 @@ type navigator struct {
 	todo     map[string]table
 	done     map[string]table
 	stack    []string
+
+	// waiting holds the tables waiting for their dependencies, a dependency already waiting is a foreign key cycle.
+	waiting map[string]bool
+
+	// ordered resolves an order for the rows of each table.
+	ordered bool
 }
 
 // newNavigator returns new Navigator instance.
-func newNavigator(logger log.Logger, db database.DB, manifest *manifest) *navigator {
+func newNavigator(logger log.Logger, db database.DB, manifest *manifest, ordered bool) *navigator {
 	nav := navigator{
 		logger,
 		db,
@@ -24,6 +33,8 @@ func newNavigator(logger log.Logger, db database.DB, manifest *manifest) *naviga
 		make(map[string]table),
 		make(map[string]table),
 		make([]string, 0),
+		make(map[string]bool),
+		ordered,
 	}
 
 	for _, item := range nav.manifest.Tables {
@@ -36,24 +47,37 @@ func newNavigator(logger log.Logger, db database.DB, manifest *manifest) *naviga
 
 // hasNext returns true if navigator has more item to consume.
 func (nav *navigator) hasNext() bool {
-	return len(nav.stack) > 0
-}
+	// tables already returned as dependencies of others are skipped
+	for len(nav.stack) > 0 {
+		if _, ok := nav.todo[nav.stack[0]]; ok {
+			return true
+		}
 
-// next returns next manifest item from stack.
-func (nav *navigator) next() (*table, error) {
-	if len(nav.stack) == 0 {
-		return nil, nil
+		nav.stack = nav.stack[1:]
 	}
 
-	// pop table from stack
-	tableName := nav.stack[0]
-	nav.stack = nav.stack[1:]
+	return false
+}
 
-	// table not in the list. Move to next table
-	if _, ok := nav.todo[tableName]; !ok {
-		return nav.next()
+// next returns next manifest item from stack, tables are returned after the tables they depend on.
+func (nav *navigator) next() (*table, error) {
+	for nav.hasNext() {
+		// pop table from stack
+		tableName := nav.stack[0]
+		nav.stack = nav.stack[1:]
+
+		t, err := nav.visit(tableName)
+		if err != nil || t != nil {
+			return t, err
+		}
 	}
 
+	return nil, ErrNoMoreTables
+}
+
+// visit returns the table if its dependencies are done, otherwise it pushes the dependencies and the table back to
+// the stack and returns nil.
+func (nav *navigator) visit(tableName string) (*table, error) {
 	// get table dependencies
 	deps, err := nav.db.GetTableDependencies(tableName)
 	if err != nil {
@@ -62,8 +86,13 @@ func (nav *navigator) next() (*table, error) {
 
 	// find which tables needs to process
 	todoDeps := make([]string, 0)
+	tableDeps := make([]string, 0)
 
 	for _, dep := range deps {
+		if dep != tableName {
+			tableDeps = append(tableDeps, dep)
+		}
+
 		_, isTodo := nav.todo[dep]
 		_, isDone := nav.done[dep]
 		if !isTodo && !isDone {
@@ -71,21 +100,35 @@ func (nav *navigator) next() (*table, error) {
 			// found, create a default entry for it
 			nav.todo[dep] = table{TableName: dep}
 		}
-		if _, ok := nav.todo[dep]; ok && tableName != dep {
-			todoDeps = append(todoDeps, dep)
+		if _, ok := nav.todo[dep]; !ok || tableName == dep {
+			continue
 		}
+
+		// the cycle is broken by loading the table before the dependency waiting for it
+		if nav.waiting[dep] {
+			nav.logger.Warn("msg", "foreign key cycle, table is loaded before its dependency", "table", tableName,
+				"dependency", dep)
+
+			continue
+		}
+
+		todoDeps = append(todoDeps, dep)
 	}
 
-	// update stack with new dependencies and get next table
+	// update stack with new dependencies, the table is visited again after them
 	if len(todoDeps) > 0 {
+		nav.waiting[tableName] = true
 		nav.stack = append(todoDeps, append([]string{tableName}, nav.stack...)...)
-		return nav.next()
+
+		return nil, nil
 	}
 
 	next := nav.todo[tableName]
+	next.dependencies = tableDeps
 
 	nav.done[tableName] = nav.todo[tableName]
 	delete(nav.todo, tableName)
+	delete(nav.waiting, tableName)
 
 	if cols := next.Columns; len(cols) == 0 {
 		cols, err = nav.db.GetTableColumns(next.TableName)
@@ -95,5 +138,71 @@ func (nav *navigator) next() (*table, error) {
 		next.Columns = cols
 	}
 
+	defs, err := nav.db.GetTableDefinition(next.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := nav.selectColumns(&next, defs); err != nil {
+		return nil, err
+	}
+
+	if err := nav.resolveSequences(&next, defs); err != nil {
+		return nil, err
+	}
+
+	// actions are rendered like queries, variables and selections are resolved before navigating
+	if next.PreActions, err = renderActions(nav.manifest, next.PreActions); err != nil {
+		return nil, errors.Wrapf(err, "pre actions of %s", next.TableName)
+	}
+
+	if next.PostActions, err = renderActions(nav.manifest, next.PostActions); err != nil {
+		return nil, errors.Wrapf(err, "post actions of %s", next.TableName)
+	}
+
+	next.resets = sequenceResets(&next)
+
+	if nav.ordered {
+		if next.orderBy, err = nav.orderBy(&next); err != nil {
+			return nil, err
+		}
+	}
+
 	return &next, nil
 }
+
+// orderBy returns the order of the table rows, by primary key when all its columns are dumped, otherwise by the text
+// of whole rows.
+func (nav *navigator) orderBy(t *table) (string, error) {
+	constraints, err := nav.db.GetTableConstraints(t.TableName)
+	if err != nil {
+		return "", err
+	}
+
+	dumped := make(map[string]bool, len(t.Columns))
+	for _, c := range t.Columns {
+		dumped[c] = true
+	}
+
+	for _, c := range constraints {
+		if c.Type != "p" {
+			continue
+		}
+
+		keys := make([]string, 0, len(c.Columns))
+
+		for _, col := range c.Columns {
+			if !dumped[col] {
+				break
+			}
+
+			keys = append(keys, "s."+quoteIdent(col))
+		}
+
+		if len(keys) == len(c.Columns) {
+			return strings.Join(keys, ", "), nil
+		}
+	}
+
+	return `ROW(s.*)::text COLLATE "C"`, nil
+}
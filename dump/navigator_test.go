@@ -0,0 +1,86 @@
+package dump
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// copiedTables returns the tables loaded by the plain dump, in order.
+func copiedTables(data string) []string {
+	tables := make([]string, 0)
+
+	for _, m := range regexp.MustCompile(`(?m)^COPY (\S+) `).FindAllStringSubmatch(data, -1) {
+		tables = append(tables, m[1])
+	}
+
+	return tables
+}
+
+func navigatorTestDB(deps map[string][]string) *fakeDB {
+	db := &fakeDB{tables: make(map[string]fakeTable)}
+
+	for name, d := range deps {
+		db.tables[name] = fakeTable{columns: []database.ColumnDefinition{{Name: "id", Type: "integer"}}, deps: d}
+	}
+
+	return db
+}
+
+func TestDumpDependencyOrder(t *testing.T) {
+	db := navigatorTestDB(map[string][]string{
+		"orders":      {"users", "products"},
+		"users":       nil,
+		"products":    nil,
+		"order_lines": {"orders", "products"},
+	})
+
+	// children are listed before their parents, parents are loaded first and only once
+	manifest := `
+tables:
+  - table: order_lines
+  - table: orders
+  - table: users
+  - table: products
+`
+
+	want := []string{"users", "products", "orders", "order_lines"}
+
+	out := testDump(t, db, manifest, Config{Format: FormatPlain})
+	if got := copiedTables(out.objects[".sql"].String()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected table order %v, want %v", got, want)
+	}
+
+	out = testDump(t, db, manifest, Config{Format: FormatCustom})
+	entries, _ := readArchive(t, out.objects[".dump"].Bytes())
+
+	got := make([]string, 0)
+	for _, e := range entries {
+		if e.desc == "TABLE DATA" {
+			got = append(got, e.tag)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected archive order %v, want %v", got, want)
+	}
+}
+
+func TestDumpDependencyCycle(t *testing.T) {
+	db := navigatorTestDB(map[string][]string{
+		"a":    {"b"},
+		"b":    {"c"},
+		"c":    {"a"},
+		"self": {"self"},
+	})
+
+	out := testDump(t, db, "tables:\n  - table: a\n  - table: self", Config{Format: FormatPlain})
+
+	// the cycle is broken at the table waiting for its dependencies
+	want := []string{"c", "b", "a", "self"}
+	if got := copiedTables(out.objects[".sql"].String()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected table order %v, want %v", got, want)
+	}
+}
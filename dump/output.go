@@ -0,0 +1,47 @@
+package dump
+
+import (
+	"io"
+)
+
+// Output is the destination of a dump. A dump is written as one or more objects, each named by a suffix appended to
+// the dump name, e.g. ".sql" for a single file or "/users.csv" for a file in the dump directory.
+type Output interface {
+	// Create creates an object with the given suffix, the object is stored once the writer is closed.
+	Create(suffix string) (io.WriteCloser, error)
+}
+
+// Boundary is implemented by object writers splitting objects into parts. Boundaries, e.g. the start of a table, are
+// the preferred places to start a new part.
+type Boundary interface {
+	// Boundary marks the current position as a boundary
+	Boundary()
+}
+
+// markBoundary marks a boundary if the writer supports it.
+func markBoundary(w io.Writer) {
+	if b, ok := w.(Boundary); ok {
+		b.Boundary()
+	}
+}
+
+// writeObject creates an object and writes it using the given function. Object is aborted if the function fails, so
+// a partially written object is not stored as a complete one.
+func writeObject(out Output, suffix string, fn func(w io.Writer) error) error {
+	w, err := out.Create(suffix)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(w); err != nil {
+		if a, ok := w.(interface{ CloseWithError(error) error }); ok {
+			_ = a.CloseWithError(err)
+		} else {
+			_ = w.Close()
+		}
+
+		return err
+	}
+
+	return w.Close()
+}
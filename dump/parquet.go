@@ -0,0 +1,254 @@
+package dump
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/parquet"
+	"github.com/pkg/errors"
+)
+
+var (
+	// numericType matches numeric types having precision and scale
+	numericType = regexp.MustCompile(`^numeric\((\d+),(\d+)\)$`)
+)
+
+// maximum precision of decimal columns, wider numerics are written as strings since most readers don't support them.
+const maxDecimalPrecision = 38
+
+// parquetColumn converts JSON values of a column to parquet values.
+type parquetColumn struct {
+	parquet.Column
+
+	// raw keeps JSON values as their text, e.g. json and array columns.
+	raw bool
+}
+
+// writeParquet writes table data as a parquet file. Rows are read with the same query as JSON Lines and converted
+// to the types of the parquet schema.
+func (d *dumper) writeParquet(ctx context.Context, w io.Writer, t *table, columns []database.Column) error {
+	source, err := copyFrom(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	pcs := parquetColumns(columns)
+
+	schema := make([]parquet.Column, 0, len(pcs))
+	for _, c := range pcs {
+		schema = append(schema, c.Column)
+	}
+
+	pw, err := parquet.NewWriter(w, schema, d.cfg.ParquetCompression, d.cfg.ParquetRowGroupSize)
+	if err != nil {
+		return err
+	}
+
+	row := make([]interface{}, len(pcs))
+
+	err = d.scanJSONRows(ctx, jsonQuery(columns, source, t.orderBy), func(values map[string]json.RawMessage) error {
+		for i, c := range pcs {
+			v, err := c.value(values[c.Name])
+			if err != nil {
+				return errors.Wrapf(err, "failed to convert column %s", c.Name)
+			}
+
+			row[i] = v
+		}
+
+		return pw.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	return pw.Close()
+}
+
+// parquetColumns maps PostgreSQL column types to parquet column kinds. Types without a parquet counterpart, e.g. json
+// and arrays, are written as strings.
+func parquetColumns(columns []database.Column) []parquetColumn {
+	pcs := make([]parquetColumn, 0, len(columns))
+
+	for _, c := range columns {
+		pc := parquetColumn{Column: parquet.Column{Name: c.Name, Kind: parquet.KindString}}
+
+		switch baseType(c.Type) {
+		case "boolean":
+			pc.Kind = parquet.KindBoolean
+		case "smallint":
+			pc.Kind = parquet.KindInt16
+		case "integer":
+			pc.Kind = parquet.KindInt32
+		case "bigint":
+			pc.Kind = parquet.KindInt64
+		case "real":
+			pc.Kind = parquet.KindFloat
+		case "double precision":
+			pc.Kind = parquet.KindDouble
+		case "numeric":
+			// numerics without precision have arbitrary scale, kept as strings
+			if m := numericType.FindStringSubmatch(c.Type); m != nil {
+				precision, _ := strconv.Atoi(m[1])
+				scale, _ := strconv.Atoi(m[2])
+
+				if precision <= maxDecimalPrecision {
+					pc.Kind, pc.Precision, pc.Scale = parquet.KindDecimal, precision, scale
+				}
+			}
+		case "date":
+			pc.Kind = parquet.KindDate
+		case "timestamp with time zone":
+			pc.Kind = parquet.KindTimestamp
+		case "timestamp without time zone":
+			pc.Kind = parquet.KindLocalTimestamp
+		case "bytea":
+			pc.Kind = parquet.KindBytes
+		case "json", "jsonb":
+			pc.raw = true
+		}
+
+		if strings.HasSuffix(c.Type, "[]") {
+			pc.Kind, pc.raw = parquet.KindString, true
+		}
+
+		pcs = append(pcs, pc)
+	}
+
+	return pcs
+}
+
+// value converts the JSON value to the parquet value of the column. Values parquet can't represent, e.g. infinite
+// dates and NaN decimals, are written as nulls.
+func (c parquetColumn) value(v json.RawMessage) (interface{}, error) {
+	if len(v) == 0 || string(v) == "null" {
+		return nil, nil
+	}
+
+	if c.raw {
+		return string(v), nil
+	}
+
+	switch c.Kind {
+	case parquet.KindBoolean:
+		var b bool
+		err := json.Unmarshal(v, &b)
+
+		return b, err
+	case parquet.KindInt16, parquet.KindInt32:
+		i, err := strconv.ParseInt(string(v), 10, 32)
+
+		return int32(i), err
+	case parquet.KindInt64:
+		return strconv.ParseInt(string(v), 10, 64)
+	case parquet.KindFloat, parquet.KindDouble:
+		return c.float(v)
+	}
+
+	// remaining kinds are converted from strings
+	var s string
+	if v[0] == '"' {
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, err
+		}
+	} else {
+		s = string(v)
+	}
+
+	switch c.Kind {
+	case parquet.KindBytes:
+		return base64.StdEncoding.DecodeString(s)
+	case parquet.KindDecimal:
+		return decimalValue(s, c.Scale)
+	case parquet.KindDate:
+		if strings.HasSuffix(s, "infinity") {
+			return nil, nil
+		}
+
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return nil, err
+		}
+
+		// durations saturate beyond 292 years, seconds of the whole date range fit in int64
+		return int32(floorDiv(t.Unix(), 24*60*60)), nil
+	case parquet.KindTimestamp, parquet.KindLocalTimestamp:
+		if strings.HasSuffix(s, "infinity") {
+			return nil, nil
+		}
+
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+
+		return t.Unix()*1e6 + int64(t.Nanosecond()/1e3), nil
+	default:
+		return s, nil
+	}
+}
+
+// floorDiv returns the quotient of a and b rounded towards negative infinity.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+
+	return q
+}
+
+// float converts JSON numbers and the strings row_to_json uses for special values to floats.
+func (c parquetColumn) float(v json.RawMessage) (interface{}, error) {
+	s := string(v)
+
+	switch s {
+	case `"NaN"`:
+		s = "NaN"
+	case `"Infinity"`:
+		s = "+Inf"
+	case `"-Infinity"`:
+		s = "-Inf"
+	}
+
+	if c.Kind == parquet.KindFloat {
+		f, err := strconv.ParseFloat(s, 32)
+
+		return float32(f), err
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
+
+// decimalValue returns the unscaled value of the numeric text with the given scale.
+func decimalValue(s string, scale int) (interface{}, error) {
+	if s == "NaN" {
+		return nil, nil
+	}
+
+	digits := s
+	frac := ""
+
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		digits, frac = s[:i], s[i+1:]
+	}
+
+	if len(frac) > scale {
+		return nil, errors.Errorf("numeric %s exceeds scale %d", s, scale)
+	}
+
+	d, ok := new(big.Int).SetString(digits+frac+strings.Repeat("0", scale-len(frac)), 10)
+	if !ok {
+		return nil, errors.Errorf("invalid numeric %s", s)
+	}
+
+	return d, nil
+}
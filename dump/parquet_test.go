@@ -0,0 +1,97 @@
+package dump
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/parquet"
+)
+
+func TestParquetValue(t *testing.T) {
+	columns := parquetColumns([]database.Column{
+		{Name: "date", Type: "date"},
+		{Name: "ts", Type: "timestamp with time zone"},
+		{Name: "local", Type: "timestamp without time zone"},
+		{Name: "price", Type: "numeric(10,2)"},
+		{Name: "amount", Type: "numeric"},
+		{Name: "ratio", Type: "real"},
+		{Name: "small", Type: "smallint"},
+		{Name: "flag", Type: "boolean"},
+		{Name: "data", Type: "bytea"},
+		{Name: "doc", Type: "jsonb"},
+		{Name: "tags", Type: "text[]"},
+	})
+
+	byName := make(map[string]parquetColumn, len(columns))
+	for _, c := range columns {
+		byName[c.Name] = c
+	}
+
+	if kind := byName["amount"].Kind; kind != parquet.KindString {
+		t.Errorf("numeric without precision has kind %v", kind)
+	}
+
+	tests := []struct {
+		column string
+		in     string
+		want   interface{}
+	}{
+		{column: "date", in: `"1970-01-01"`, want: int32(0)},
+		{column: "date", in: `"1969-12-31"`, want: int32(-1)},
+		{column: "date", in: `"2024-02-29"`, want: int32(19782)},
+		{column: "date", in: `"9999-12-31"`, want: int32(2932896)},
+		{column: "date", in: `"1600-01-01"`, want: int32(-135140)},
+		{column: "date", in: `"0001-01-01"`, want: int32(-719162)},
+		{column: "date", in: `"infinity"`, want: nil},
+		{column: "date", in: `"-infinity"`, want: nil},
+		{column: "date", in: `null`, want: nil},
+		{column: "ts", in: `"1970-01-01T00:00:01.000002Z"`, want: int64(1000002)},
+		{column: "ts", in: `"1969-12-31T23:59:59.500000Z"`, want: int64(-500000)},
+		{column: "ts", in: `"9999-12-31T23:59:59.000000Z"`, want: int64(253402300799e6)},
+		{column: "local", in: `"1600-01-01T00:00:00.000000Z"`, want: int64(-11676096000e6)},
+		{column: "price", in: `"12.5"`, want: big.NewInt(1250)},
+		{column: "price", in: `"-0.05"`, want: big.NewInt(-5)},
+		{column: "price", in: `"NaN"`, want: nil},
+		{column: "amount", in: `"1.000000000000000000001"`, want: "1.000000000000000000001"},
+		{column: "ratio", in: `"NaN"`, want: float32(math.NaN())},
+		{column: "ratio", in: `"-Infinity"`, want: float32(math.Inf(-1))},
+		{column: "small", in: `-32768`, want: int32(-32768)},
+		{column: "flag", in: `true`, want: true},
+		{column: "data", in: `"AP8="`, want: []byte{0, 0xff}},
+		{column: "doc", in: `{"a": [1, 2]}`, want: `{"a": [1, 2]}`},
+		{column: "tags", in: `["a","b"]`, want: `["a","b"]`},
+	}
+
+	for _, tt := range tests {
+		got, err := byName[tt.column].value(json.RawMessage(tt.in))
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.column, tt.in, err)
+
+			continue
+		}
+
+		if f, ok := tt.want.(float32); ok && math.IsNaN(float64(f)) {
+			if g, ok := got.(float32); !ok || !math.IsNaN(float64(g)) {
+				t.Errorf("%s %s: %#v, want NaN", tt.column, tt.in, got)
+			}
+
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s: %#v, want %#v", tt.column, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFloorDiv(t *testing.T) {
+	for _, tt := range [][3]int64{{7, 2, 3}, {-7, 2, -4}, {-8, 2, -4}, {7, -2, -4}, {-7, -2, 3}, {0, 5, 0}} {
+		if got := floorDiv(tt[0], tt[1]); got != tt[2] {
+			t.Errorf("floorDiv(%d, %d) = %d, want %d", tt[0], tt[1], got, tt[2])
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package dump
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/helpers"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// Restorer provides functionality to restore dumps having a file per table into a database.
+type Restorer interface {
+	// restores the dump in a single transaction, read data is read through the reader returned by progress, e.g. to
+	// report it
+	Restore(ctx context.Context, in Input, progress func(io.Reader) io.Reader) error
+}
+
+type restorer struct {
+	logger   log.Logger
+	target   database.DB
+	truncate bool
+}
+
+// NewRestorer creates Restorer instance.
+func NewRestorer(logger log.Logger, target database.DB, cfg Config) Restorer {
+	logger.Debug("msg", "create restorer instance", "truncate", cfg.Truncate)
+
+	return &restorer{logger: logger, target: target, truncate: cfg.Truncate}
+}
+
+func (r *restorer) Restore(ctx context.Context, in Input, progress func(io.Reader) io.Reader) (err error) {
+	m, err := r.readManifest(in)
+	if err != nil {
+		return err
+	}
+
+	// COPY options of the formats written by COPY
+	var options string
+
+	switch m.Format {
+	case FormatBinary:
+		options = "FORMAT binary"
+	case FormatCSV:
+		options = "FORMAT csv, HEADER"
+	default:
+		return ErrNotRestorable
+	}
+
+	tx, err := r.target.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		if rerr := tx.Rollback(); rerr != nil {
+			r.logger.Error("msg", "failed to rollback target transaction", "error", rerr)
+		}
+	}()
+
+	if err := execActions(r.logger, tx, m.Before); err != nil {
+		return err
+	}
+
+	if r.truncate && len(m.Tables) > 0 {
+		names := make([]string, 0, len(m.Tables))
+		for _, t := range m.Tables {
+			names = append(names, t.Table)
+		}
+
+		if err := tx.Exec(fmt.Sprintf("TRUNCATE TABLE %s", strings.Join(names, ", "))); err != nil {
+			return err
+		}
+	}
+
+	for _, t := range m.Tables {
+		if err := execActions(r.logger, tx, t.PreActions); err != nil {
+			return err
+		}
+
+		if err := r.restoreTable(in, tx, t, options, progress); err != nil {
+			return err
+		}
+
+		if err := execActions(r.logger, tx, t.PostActions); err != nil {
+			return err
+		}
+	}
+
+	if err := execActions(r.logger, tx, m.After); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "failed to commit target transaction")
+	}
+
+	return nil
+}
+
+// readManifest reads the manifest describing the dump files.
+func (r *restorer) readManifest(in Input) (*filesManifest, error) {
+	rc, err := in.Open("/" + filesManifestName)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open dump manifest")
+	}
+
+	defer helpers.CloseWithErrLogf(r.logger, rc, "restore, close manifest")
+
+	var m filesManifest
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		return nil, errors.Wrap(err, "failed to read dump manifest")
+	}
+
+	return &m, nil
+}
+
+// restoreTable loads the table file with COPY FROM STDIN.
+func (r *restorer) restoreTable(
+	in Input, tx database.Tx, t fileEntry, options string, progress func(io.Reader) io.Reader,
+) error {
+	rc, err := in.Open("/" + t.File)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open file of table %s", t.Table)
+	}
+
+	defer helpers.CloseWithErrLogf(r.logger, rc, "restore, close table file")
+
+	columns := make([]string, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		columns = append(columns, quoteIdent(c.Name))
+	}
+
+	query := fmt.Sprintf("COPY %s (%s) FROM STDIN WITH (%s)", t.Table, strings.Join(columns, ", "), options)
+
+	var data io.Reader = rc
+	if progress != nil {
+		data = progress(rc)
+	}
+
+	if err := tx.CopyFrom(data, query); err != nil {
+		return errors.Wrapf(err, "failed to restore table %s", t.Table)
+	}
+
+	r.logger.Debug("msg", "table restored", "table", t.Table)
+
+	return nil
+}
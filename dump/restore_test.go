@@ -0,0 +1,80 @@
+package dump
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// memInput is an Input serving the objects of a memOutput.
+type memInput struct {
+	objects map[string]string
+}
+
+func (i *memInput) Open(suffix string) (io.ReadCloser, error) {
+	data, ok := i.objects[suffix]
+	if !ok {
+		return nil, errors.Errorf("unknown object %s", suffix)
+	}
+
+	return ioutil.NopCloser(bytes.NewBufferString(data)), nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n *int
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += n
+
+	return n, err
+}
+
+func TestRestore(t *testing.T) {
+	in := &memInput{objects: map[string]string{
+		"/manifest.json": `{
+			"format": "csv",
+			"before": ["SET session_replication_role = replica"],
+			"tables": [
+				{"table": "users", "file": "users.csv", "columns": [{"name": "id", "type": "integer"}]},
+				{"table": "sales.orders", "file": "sales.orders.csv", "columns": [{"name": "id", "type": "bigint"}],
+				 "post_actions": ["ANALYZE sales.orders"]}
+			]
+		}`,
+		"/users.csv":        "id\n1\n",
+		"/sales.orders.csv": "id\n10\n11\n",
+	}}
+
+	target := &fakeTarget{}
+	read := 0
+
+	err := NewRestorer(newTestLogger(t), target, Config{Truncate: true}).Restore(context.Background(), in,
+		func(r io.Reader) io.Reader { return countingReader{r: r, n: &read} })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"SET session_replication_role = replica",
+		"TRUNCATE TABLE users, sales.orders",
+		`COPY users ("id") FROM STDIN WITH (FORMAT csv, HEADER)`,
+		`COPY sales.orders ("id") FROM STDIN WITH (FORMAT csv, HEADER)`,
+		"ANALYZE sales.orders",
+	}
+
+	if !reflect.DeepEqual(target.statements, want) || !target.committed {
+		t.Fatalf("unexpected statements %q, committed %v", target.statements, target.committed)
+	}
+
+	if read != target.data.Len() || target.data.String() != "id\n1\nid\n10\n11\n" {
+		t.Fatalf("%d bytes reported as progress, restored %q", read, target.data.String())
+	}
+}
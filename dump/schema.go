@@ -0,0 +1,348 @@
+package dump
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentManifestVersion is the version of the manifest format, manifests without a version are the current version.
+const CurrentManifestVersion = 1
+
+// manifestMigrations migrate manifest documents of older versions, the migration at index i migrates version i+1 to
+// version i+2.
+var manifestMigrations []func(doc *yaml.Node) error
+
+var unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// decodeManifest strictly decodes the manifest file content. Documents of older versions are migrated to the current
+// version first, and keys unknown to the current version are errors with their positions.
+func decodeManifest(file string, data []byte, m *manifest) error {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errors.Wrapf(err, "failed to parse manifest file %s", file)
+	}
+
+	// an empty file is an empty manifest
+	if len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := doc.Content[0]
+
+	version, err := manifestVersion(file, root)
+	if err != nil {
+		return err
+	}
+
+	for v := version; v < CurrentManifestVersion; v++ {
+		if err := manifestMigrations[v-1](root); err != nil {
+			return errors.Wrapf(err, "failed to migrate manifest file %s from version %d", file, v)
+		}
+	}
+
+	if errs := checkNode(root, reflect.TypeOf(*m), ""); len(errs) > 0 {
+		for i, e := range errs {
+			errs[i] = file + ":" + e
+		}
+
+		return errors.Wrap(ErrInvalidManifest, strings.Join(errs, "\n"))
+	}
+
+	if err := root.Decode(m); err != nil {
+		return errors.Wrapf(err, "failed to decode manifest file %s", file)
+	}
+
+	m.ManifestVersion = CurrentManifestVersion
+
+	return nil
+}
+
+// manifestVersion returns the manifest_version of the document, or the current version if it isn't given.
+func manifestVersion(file string, root *yaml.Node) (int, error) {
+	if root.Kind != yaml.MappingNode {
+		return CurrentManifestVersion, nil
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "manifest_version" {
+			continue
+		}
+
+		n := root.Content[i+1]
+
+		v, err := strconv.Atoi(n.Value)
+		if err != nil || n.Kind != yaml.ScalarNode || v < 1 {
+			return 0, errors.Wrapf(ErrInvalidManifest, "%s:%d:%d: manifest_version must be a positive integer",
+				file, n.Line, n.Column)
+		}
+
+		if v > CurrentManifestVersion {
+			return 0, errors.Wrapf(ErrUnsupportedManifestVersion, "%s:%d:%d: version %d, latest supported version is %d",
+				file, n.Line, n.Column, v, CurrentManifestVersion)
+		}
+
+		return v, nil
+	}
+
+	return CurrentManifestVersion, nil
+}
+
+// checkNode checks the node is decodable to the type without unknown keys, errors are prefixed by their positions.
+func checkNode(n *yaml.Node, t reflect.Type, path string) []string {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+
+	// nulls are zero values
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+
+	// types decoding themselves accept scalars, e.g. variable queries given as strings
+	if n.Kind == yaml.ScalarNode && reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return []string{nodeError(n, path, "expected a mapping")}
+		}
+
+		fields := yamlFields(t)
+		errs := make([]string, 0)
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k, v := n.Content[i], n.Content[i+1]
+
+			f, ok := fields[k.Value]
+			if !ok {
+				errs = append(errs, nodeError(k, path, fmt.Sprintf("unknown key %q%s", k.Value, suggestKey(k.Value, fields))))
+
+				continue
+			}
+
+			errs = append(errs, checkNode(v, f.Type, joinPath(path, k.Value))...)
+		}
+
+		for _, name := range missingKeys(n, fields) {
+			errs = append(errs, nodeError(n, path, fmt.Sprintf("missing key %q", name)))
+		}
+
+		return errs
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return []string{nodeError(n, path, "expected a mapping")}
+		}
+
+		errs := make([]string, 0)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			errs = append(errs, checkNode(n.Content[i+1], t.Elem(), joinPath(path, n.Content[i].Value))...)
+		}
+
+		return errs
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return []string{nodeError(n, path, "expected a list")}
+		}
+
+		errs := make([]string, 0)
+		for i, item := range n.Content {
+			errs = append(errs, checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+
+		return errs
+	default:
+		if n.Kind != yaml.ScalarNode {
+			return []string{nodeError(n, path, "expected a single value")}
+		}
+
+		return nil
+	}
+}
+
+// yamlFields returns the exported fields of the struct type by their yaml keys.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		fields[name] = f
+	}
+
+	return fields
+}
+
+// missingKeys returns the required keys missing in the mapping node, in the order of their names.
+func missingKeys(n *yaml.Node, fields map[string]reflect.StructField) []string {
+	given := make(map[string]bool, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		given[n.Content[i].Value] = true
+	}
+
+	missing := make([]string, 0)
+
+	for name, f := range fields {
+		if f.Tag.Get("required") == "true" && !given[name] {
+			missing = append(missing, name)
+		}
+	}
+
+	sort.Strings(missing)
+
+	return missing
+}
+
+func nodeError(n *yaml.Node, path, msg string) string {
+	if path == "" {
+		return fmt.Sprintf("%d:%d: %s", n.Line, n.Column, msg)
+	}
+
+	return fmt.Sprintf("%d:%d: %s: %s", n.Line, n.Column, path, msg)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// suggestKey returns a suggestion of the known key closest to the unknown one, if any is close enough.
+func suggestKey(key string, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3
+
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDist || (d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+
+	if best == "" {
+		return ""
+	}
+
+	return fmt.Sprintf(", did you mean %q?", best)
+}
+
+// editDistance returns the Levenshtein distance of the strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = minInt(minInt(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// ManifestSchema returns the JSON Schema of manifest files.
+func ManifestSchema() ([]byte, error) {
+	schema := typeSchema(reflect.TypeOf(manifest{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "pdd manifest"
+
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// typeSchema returns the JSON Schema of the values decoded to the type.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	schema := make(map[string]interface{})
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return schema
+	case reflect.Struct:
+		fields := yamlFields(t)
+		properties := make(map[string]interface{}, len(fields))
+		required := make([]string, 0)
+
+		for name, f := range fields {
+			p := typeSchema(f.Type)
+			if desc := f.Tag.Get("desc"); desc != "" {
+				p["description"] = desc
+			}
+
+			if f.Tag.Get("required") == "true" {
+				required = append(required, name)
+			}
+
+			properties[name] = p
+		}
+
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+	case reflect.Map:
+		schema["type"] = "object"
+		schema["additionalProperties"] = typeSchema(t.Elem())
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = typeSchema(t.Elem())
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int, reflect.Int64:
+		schema["type"] = "integer"
+	default:
+		schema["type"] = "string"
+	}
+
+	// types decoding themselves accept strings, e.g. variable queries given as strings
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return map[string]interface{}{"anyOf": []interface{}{map[string]interface{}{"type": "string"}, schema}}
+	}
+
+	return schema
+}
@@ -0,0 +1,104 @@
+package dump
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckNode(t *testing.T) {
+	tests := []struct {
+		doc  string
+		errs []string
+	}{
+		{doc: "tables:\n  - table: users\n    query: SELECT 1\n"},
+		// variable queries decode themselves from strings, nulls are zero values
+		{doc: "vars_from_query:\n  max_id: SELECT max(id) FROM users\nbefore: ~\ntables: []\n"},
+		{doc: "defaults: &d {table: users}\ntables: [*d]\n", errs: []string{`1:1: unknown key "defaults"`}},
+		{
+			doc:  "tables:\n  - table: users\n    querry: SELECT 1\n",
+			errs: []string{`3:5: tables[0]: unknown key "querry", did you mean "query"?`},
+		},
+		{
+			doc:  "tables:\n  - query: SELECT 1\n",
+			errs: []string{`2:5: tables[0]: missing key "table"`},
+		},
+		{
+			doc: "tables: users\nvars: [a]\nbefore:\n  - {a: b}\n",
+			errs: []string{
+				"1:9: tables: expected a list",
+				"2:7: vars: expected a mapping",
+				"4:5: before[0]: expected a single value",
+			},
+		},
+		{doc: "- table: users\n", errs: []string{"1:1: expected a mapping"}},
+	}
+
+	for _, test := range tests {
+		var doc yaml.Node
+		if err := yaml.Unmarshal([]byte(test.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+
+		errs := checkNode(doc.Content[0], reflect.TypeOf(manifest{}), "")
+		if len(errs) == 0 {
+			errs = nil
+		}
+
+		if !reflect.DeepEqual(errs, test.errs) {
+			t.Errorf("unexpected errors of\n%s%q\nwant %q", test.doc, errs, test.errs)
+		}
+	}
+}
+
+func TestDecodeManifestVersion(t *testing.T) {
+	var m manifest
+
+	err := decodeManifest("m.yaml", []byte("manifest_version: 2\ntables: []\n"), &m)
+	if errors.Cause(err) != ErrUnsupportedManifestVersion {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	err = decodeManifest("m.yaml", []byte("manifest_version: x\n"), &m)
+	if errors.Cause(err) != ErrInvalidManifest || !strings.Contains(err.Error(), "m.yaml:1:19:") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := decodeManifest("m.yaml", []byte("manifest_version: 1\ntables: [{table: users}]\n"), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.ManifestVersion != CurrentManifestVersion || len(m.Tables) != 1 {
+		t.Fatalf("unexpected manifest %+v", m)
+	}
+}
+
+func TestManifestSchema(t *testing.T) {
+	data, err := ManifestSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// unexported fields aren't part of the schema
+	for _, key := range []string{"tables", "vars", "selections", "before"} {
+		if _, ok := schema.Properties[key]; !ok {
+			t.Errorf("key %q missing in schema properties", key)
+		}
+	}
+
+	if len(schema.Properties) != len(yamlFields(reflect.TypeOf(manifest{}))) {
+		t.Errorf("unexpected properties %d", len(schema.Properties))
+	}
+}
@@ -0,0 +1,80 @@
+package dump
+
+import (
+	"fmt"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// selection is a named row set materialized into a temporary table.
+type selection struct {
+	Name  string   `yaml:"name" required:"true" desc:"Name of the selection, used as sel \"name\" in queries"`
+	Query string   `yaml:"query" required:"true" desc:"Query template selecting the rows"`
+	Keys  []string `yaml:"keys,flow,omitempty" desc:"Columns of the index of the temporary table"`
+}
+
+// selectionIndex returns the index of the selection, or -1 if the manifest has no selection with the name.
+func (m *manifest) selectionIndex(name string) int {
+	for i, s := range m.Selections {
+		if s.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// materialize creates a temporary table for each selection in the order of the manifest, indexed on the key columns.
+// Selection queries are rendered with the variables and can use the earlier selections. Temporary tables belong to
+// the connection of the snapshot and are dropped with it.
+func (m *manifest) materialize(logger log.Logger, s database.Snapshot) error {
+	m.selected = make(map[string]bool, len(m.Selections))
+
+	for _, sel := range m.Selections {
+		query, err := renderQuery(m, sel.Query)
+		if err != nil {
+			return errors.Wrapf(err, "failed to render query of selection %q", sel.Name)
+		}
+
+		name := selectionTable(sel.Name)
+
+		if err := s.Exec(fmt.Sprintf("CREATE TEMPORARY TABLE %s AS %s", name, query)); err != nil {
+			return errors.Wrapf(err, "failed to materialize selection %q", sel.Name)
+		}
+
+		if len(sel.Keys) > 0 {
+			if err := s.Exec(fmt.Sprintf("CREATE INDEX ON %s (%s)", name, quoteColumns(sel.Keys))); err != nil {
+				return errors.Wrapf(err, "failed to index selection %q", sel.Name)
+			}
+		}
+
+		// temporary tables are not analyzed by autovacuum
+		if err := s.Exec("ANALYZE " + name); err != nil {
+			return errors.Wrapf(err, "failed to analyze selection %q", sel.Name)
+		}
+
+		m.selected[sel.Name] = true
+
+		logger.Debug("msg", "materialize selection", "name", sel.Name, "keys", quoteColumns(sel.Keys))
+	}
+
+	return nil
+}
+
+// sel returns the temporary table of the materialized selection.
+func (m *manifest) sel(name string) (string, error) {
+	if !m.selected[name] {
+		return "", errors.Wrap(ErrUnknownSelection, name)
+	}
+
+	return selectionTable(name), nil
+}
+
+// selectionTable returns the name of the temporary table of the selection.
+func selectionTable(name string) string {
+	table, _ := ident("pg_temp", name)
+
+	return table
+}
@@ -0,0 +1,57 @@
+package dump
+
+import (
+	"fmt"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+// resolveSequences resolves the sequences owned by the dumped columns of the table, and whether the table is loaded
+// overriding its GENERATED ALWAYS identity columns.
+func (nav *navigator) resolveSequences(t *table, defs []database.ColumnDefinition) error {
+	dumped := make(map[string]bool, len(t.Columns))
+	for _, name := range t.Columns {
+		dumped[name] = true
+	}
+
+	for _, c := range defs {
+		if c.Identity == "a" && dumped[c.Name] {
+			t.overriding = true
+		}
+	}
+
+	sequences, err := nav.db.GetTableSequences(t.TableName)
+	if err != nil {
+		return err
+	}
+
+	// columns not dumped are loaded with values of their sequences, which need no reset
+	for _, seq := range sequences {
+		if dumped[seq.Column] {
+			t.sequences = append(t.sequences, seq)
+		}
+	}
+
+	return nil
+}
+
+// sequenceResets returns the statements setting the sequences of the table to the largest loaded values of their
+// columns, sequences of empty tables are kept as they are.
+func sequenceResets(t *table) []string {
+	resets := make([]string, 0, len(t.sequences))
+
+	for _, seq := range t.sequences {
+		col := quoteIdent(seq.Column)
+
+		resets = append(resets, fmt.Sprintf("SELECT pg_catalog.setval(%s, MAX(%s)) FROM %s HAVING MAX(%s) IS NOT NULL",
+			quoteLiteral(seq.Name), col, t.TableName, col))
+	}
+
+	return resets
+}
+
+// afterLoad returns the statements executed after loading the table. Sequences are reset first, so post actions of
+// the manifest can still set them differently.
+func (t *table) afterLoad() []string {
+	return append(append([]string(nil), t.resets...), t.PostActions...)
+}
@@ -0,0 +1,73 @@
+package dump
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+func TestResolveSequences(t *testing.T) {
+	db := &fakeDB{tables: map[string]fakeTable{"users": {
+		sequences: []database.Sequence{{Name: "users_id_seq", Column: "id"}, {Name: "users_ref_seq", Column: "ref"}},
+	}}}
+
+	defs := []database.ColumnDefinition{
+		{Name: "id", Type: "bigint", NotNull: true, Identity: "a"},
+		{Name: "ref", Type: "integer", Default: "nextval('users_ref_seq'::regclass)"},
+	}
+
+	nav := &navigator{db: db}
+
+	// sequences of columns not dumped need no reset
+	tbl := table{TableName: "users", Columns: []string{"id"}}
+	if err := nav.resolveSequences(&tbl, defs); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []database.Sequence{{Name: "users_id_seq", Column: "id"}}; !reflect.DeepEqual(tbl.sequences, want) ||
+		!tbl.overriding {
+		t.Fatalf("unexpected sequences %v, overriding %v", tbl.sequences, tbl.overriding)
+	}
+
+	tbl = table{TableName: "users", Columns: []string{"ref"}}
+	if err := nav.resolveSequences(&tbl, defs); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tbl.sequences) != 1 || tbl.overriding {
+		t.Fatalf("unexpected sequences %v, overriding %v", tbl.sequences, tbl.overriding)
+	}
+}
+
+func TestSequenceResets(t *testing.T) {
+	tbl := &table{
+		TableName:   "sales.orders",
+		PostActions: []string{"SELECT setval('o''s_seq', 100)"},
+		sequences: []database.Sequence{
+			{Name: "sales.orders_id_seq", Column: "id"},
+			{Name: `sales."O'Brien_seq"`, Column: `Ref "No"`},
+		},
+	}
+
+	tbl.resets = sequenceResets(tbl)
+
+	want := []string{
+		`SELECT pg_catalog.setval('sales.orders_id_seq', MAX("id")) FROM sales.orders HAVING MAX("id") IS NOT NULL`,
+		`SELECT pg_catalog.setval('sales."O''Brien_seq"', MAX("Ref ""No""")) FROM sales.orders ` +
+			`HAVING MAX("Ref ""No""") IS NOT NULL`,
+	}
+
+	if !reflect.DeepEqual(tbl.resets, want) {
+		t.Fatalf("unexpected resets\n%q\nwant\n%q", tbl.resets, want)
+	}
+
+	// post actions of the manifest run after the resets
+	if got := tbl.afterLoad(); !reflect.DeepEqual(got, append(want, tbl.PostActions...)) {
+		t.Fatalf("unexpected statements after loading %q", got)
+	}
+
+	if resets := sequenceResets(&table{TableName: "users"}); len(resets) != 0 {
+		t.Fatalf("unexpected resets %q", resets)
+	}
+}
@@ -0,0 +1,318 @@
+package dump
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/sqlite"
+	"github.com/pkg/errors"
+)
+
+// SQLite column types, each has the affinity of its name.
+const (
+	sqliteInteger = "INTEGER"
+	sqliteReal    = "REAL"
+	sqliteNumeric = "NUMERIC"
+	sqliteText    = "TEXT"
+	sqliteBlob    = "BLOB"
+)
+
+// sqliteColumn converts JSON values of a column to SQLite values.
+type sqliteColumn struct {
+	sqlite.Column
+
+	// raw keeps JSON values as their text, e.g. json and array columns.
+	raw bool
+}
+
+// dumpSQLite dumps the database as a SQLite database. The database is written to a temporary file first, since its
+// header depends on the whole content.
+func (d *dumper) dumpSQLite(ctx context.Context, w io.Writer) error {
+	f, err := ioutil.TempFile(d.cfg.SpoolDir, "pdd-*.sqlite")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	sw := sqlite.NewWriter(f)
+
+	// keys of written tables by their SQLite names, foreign keys can only reference them
+	keys := make(map[string][][]string)
+	// tables by their SQLite names, names are case insensitive in SQLite
+	names := make(map[string]string)
+
+	for d.nav.hasNext() {
+		t, err := d.nav.next()
+		if err != nil {
+			d.logger.Error("msg", "can't fetch next table", "error", err)
+
+			return err
+		}
+
+		name := strings.ToLower(sqliteTableName(t.TableName))
+		if other, ok := names[name]; ok {
+			return errors.Wrapf(ErrDuplicateTableName, "%s and %s", other, t.TableName)
+		}
+
+		names[name] = t.TableName
+
+		if err := d.writeSQLiteTable(ctx, sw, t, keys); err != nil {
+			d.logger.Error("msg", "failed to write table", "table", t.TableName, "error", err)
+
+			return err
+		}
+	}
+
+	if err := sw.Close(); err != nil {
+		return errors.Wrap(err, "failed to write database")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, f)
+
+	return err
+}
+
+// writeSQLiteTable creates the table with the constraints covered by its dumped columns and inserts its rows.
+func (d *dumper) writeSQLiteTable(ctx context.Context, sw *sqlite.Writer, t *table, keys map[string][][]string) error {
+	columns, err := d.columnTypes(t)
+	if err != nil {
+		return err
+	}
+
+	constraints, err := d.db.GetTableConstraints(t.TableName)
+	if err != nil {
+		return err
+	}
+
+	name := sqliteTableName(t.TableName)
+	scs := sqliteColumns(columns)
+	def := sqliteTable(name, scs, constraints, keys)
+
+	tw, err := sw.CreateTable(def)
+	if err != nil {
+		return err
+	}
+
+	source, err := copyFrom(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	// rows of tables having an integer primary key are inserted in rowid order, others keep the order of the dump
+	orderBy := t.orderBy
+	if len(def.PrimaryKey) == 1 {
+		for _, c := range scs {
+			if c.Name == def.PrimaryKey[0] && c.Type == sqliteInteger {
+				orderBy = "s." + quoteIdent(c.Name)
+			}
+		}
+	}
+
+	row := make([]interface{}, len(scs))
+
+	err = d.scanJSONRows(ctx, jsonQuery(columns, source, orderBy), func(values map[string]json.RawMessage) error {
+		for i, c := range scs {
+			v, err := c.value(values[c.Name])
+			if err != nil {
+				return errors.Wrapf(err, "failed to convert column %s", c.Name)
+			}
+
+			row[i] = v
+		}
+
+		return tw.Insert(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	keys[name] = append([][]string{def.PrimaryKey}, def.Unique...)
+
+	return nil
+}
+
+// sqliteTable returns the table definition with the constraints having all their columns dumped. Foreign keys are
+// declared only when the referenced key exists in an already written table.
+func sqliteTable(name string, columns []sqliteColumn, constraints []database.Constraint, keys map[string][][]string) sqlite.Table {
+	def := sqlite.Table{Name: name}
+
+	dumped := make(map[string]bool, len(columns))
+
+	for _, c := range columns {
+		def.Columns = append(def.Columns, c.Column)
+		dumped[c.Name] = true
+	}
+
+	covered := func(cols []string) bool {
+		for _, c := range cols {
+			if !dumped[c] {
+				return false
+			}
+		}
+
+		return len(cols) > 0
+	}
+
+	for _, c := range constraints {
+		if !covered(c.Columns) {
+			continue
+		}
+
+		switch c.Type {
+		case "p":
+			def.PrimaryKey = c.Columns
+		case "u":
+			def.Unique = append(def.Unique, c.Columns)
+		case "f":
+			ref := sqliteTableName(c.RefTable)
+
+			// self references are declared, the table is written with all its keys
+			refKeys := keys[ref]
+			if ref == name {
+				refKeys = append([][]string{def.PrimaryKey}, def.Unique...)
+			}
+
+			if containsKey(refKeys, c.RefColumns) {
+				def.ForeignKeys = append(def.ForeignKeys, sqlite.ForeignKey{
+					Columns: c.Columns, Table: ref, RefColumns: c.RefColumns,
+				})
+			}
+		}
+	}
+
+	return def
+}
+
+// sqliteTableName returns the name of the table in SQLite, which has no schemas. Tables outside public schema are
+// prefixed with their schema.
+func sqliteTableName(tableName string) string {
+	schema, name := splitTableName(tableName)
+	if schema == "public" {
+		return name
+	}
+
+	return schema + "_" + name
+}
+
+func containsKey(keys [][]string, columns []string) bool {
+	for _, k := range keys {
+		if len(k) > 0 && strings.Join(k, "\x00") == strings.Join(columns, "\x00") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sqliteColumns maps PostgreSQL column types to SQLite types by their affinity.
+func sqliteColumns(columns []database.Column) []sqliteColumn {
+	scs := make([]sqliteColumn, 0, len(columns))
+
+	for _, c := range columns {
+		sc := sqliteColumn{Column: sqlite.Column{Name: c.Name, Type: sqliteText}}
+
+		switch baseType(c.Type) {
+		case "smallint", "integer", "bigint", "boolean":
+			sc.Type = sqliteInteger
+		case "real", "double precision":
+			sc.Type = sqliteReal
+		case "numeric":
+			sc.Type = sqliteNumeric
+		case "bytea":
+			sc.Type = sqliteBlob
+		case "json", "jsonb":
+			sc.raw = true
+		}
+
+		if strings.HasSuffix(c.Type, "[]") {
+			sc.Type, sc.raw = sqliteText, true
+		}
+
+		scs = append(scs, sc)
+	}
+
+	return scs
+}
+
+// value converts the JSON value to the SQLite value of the column, booleans are stored as integers.
+func (c sqliteColumn) value(v json.RawMessage) (interface{}, error) {
+	if len(v) == 0 || string(v) == "null" {
+		return nil, nil
+	}
+
+	if c.raw {
+		return string(v), nil
+	}
+
+	switch string(v) {
+	case "true":
+		return int64(1), nil
+	case "false":
+		return int64(0), nil
+	}
+
+	var s string
+	if v[0] == '"' {
+		if err := json.Unmarshal(v, &s); err != nil {
+			return nil, err
+		}
+	} else {
+		s = string(v)
+	}
+
+	switch c.Type {
+	case sqliteInteger:
+		return strconv.ParseInt(s, 10, 64)
+	case sqliteReal:
+		switch s {
+		case "NaN":
+			// SQLite has no NaN
+			return nil, nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+
+		return strconv.ParseFloat(s, 64)
+	case sqliteNumeric:
+		// numbers are stored as integers when possible, like SQLite does for columns with numeric affinity
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(f) {
+			if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+				return int64(f), nil
+			}
+
+			return f, nil
+		}
+
+		return s, nil
+	case sqliteBlob:
+		return base64.StdEncoding.DecodeString(s)
+	default:
+		return s, nil
+	}
+}
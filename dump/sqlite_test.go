@@ -0,0 +1,107 @@
+package dump
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/sqlite"
+	"github.com/pkg/errors"
+)
+
+func TestSQLiteTableName(t *testing.T) {
+	for name, want := range map[string]string{
+		"users":          "users",
+		"public.users":   "users",
+		"sales.orders":   "sales_orders",
+		`"Sales".orders`: "Sales_orders",
+	} {
+		if got := sqliteTableName(name); got != want {
+			t.Errorf("sqliteTableName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSQLiteTableForeignKeys(t *testing.T) {
+	columns := []sqliteColumn{
+		{Column: sqlite.Column{Name: "id", Type: sqliteInteger}},
+		{Column: sqlite.Column{Name: "user_id", Type: sqliteInteger}},
+		{Column: sqlite.Column{Name: "parent_id", Type: sqliteInteger}},
+		{Column: sqlite.Column{Name: "item_id", Type: sqliteInteger}},
+	}
+
+	constraints := []database.Constraint{
+		{Type: "p", Columns: []string{"id"}},
+		{Type: "f", Columns: []string{"user_id"}, RefTable: "a.users", RefColumns: []string{"id"}},
+		{Type: "f", Columns: []string{"parent_id"}, RefTable: "sales.orders", RefColumns: []string{"id"}},
+		// b.users isn't dumped, a.users is
+		{Type: "f", Columns: []string{"item_id"}, RefTable: "b.users", RefColumns: []string{"id"}},
+	}
+
+	keys := map[string][][]string{"a_users": {{"id"}}}
+
+	def := sqliteTable(sqliteTableName("sales.orders"), columns, constraints, keys)
+
+	want := []sqlite.ForeignKey{
+		{Columns: []string{"user_id"}, Table: "a_users", RefColumns: []string{"id"}},
+		{Columns: []string{"parent_id"}, Table: "sales_orders", RefColumns: []string{"id"}},
+	}
+
+	if def.Name != "sales_orders" || !reflect.DeepEqual(def.ForeignKeys, want) {
+		t.Fatalf("unexpected table %s with foreign keys %+v, want %+v", def.Name, def.ForeignKeys, want)
+	}
+}
+
+func TestDumpSQLiteDuplicateName(t *testing.T) {
+	columns := []database.ColumnDefinition{{Name: "id", Type: "integer"}}
+
+	db := &catalogDB{fakeDB: fakeDB{
+		tables: map[string]fakeTable{"a_users": {columns: columns}, "a.users": {columns: columns}},
+		copies: map[string]string{
+			jsonQuery([]database.Column{{Name: "id", Type: "integer"}}, "a_users", ""): "{\"id\":1}\n",
+		},
+	}}
+
+	file := filepath.Join(t.TempDir(), ".pdd.yaml")
+	if err := ioutil.WriteFile(file, []byte("tables:\n  - table: a_users\n  - table: a.users\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDumper(newTestLogger(t), db, Config{ManifestFile: file, Format: FormatSQLite})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Dump(context.Background(), &memOutput{}); errors.Cause(err) != ErrDuplicateTableName {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+// catalogDB serves the column types and constraints of the fakeDB tables.
+type catalogDB struct {
+	fakeDB
+}
+
+func (f *catalogDB) Snapshot(context.Context) (database.Snapshot, error) { return f, nil }
+
+func (f *catalogDB) GetTableColumnTypes(name string) ([]database.Column, error) {
+	t, err := f.table(name)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]database.Column, 0, len(t.columns))
+	for _, c := range t.columns {
+		columns = append(columns, database.Column{Name: c.Name, Type: c.Type})
+	}
+
+	return columns, nil
+}
+
+func (f *catalogDB) GetTableConstraints(name string) ([]database.Constraint, error) {
+	t, err := f.table(name)
+	return t.constraints, err
+}
@@ -0,0 +1,401 @@
+package dump
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// names of the tar bundle entries.
+const (
+	tarTOCName         = "toc.json"
+	tarManifestName    = "manifest.yaml"
+	tarSchemaName      = "schema.sql"
+	tarBeforeName      = "before.sql"
+	tarPostActionsName = "post_actions.sql"
+	tarAfterName       = "after.sql"
+	tarDataDir         = "data/"
+)
+
+// tarTOC is the table of contents of a tar bundle, it is the first entry of the bundle.
+type tarTOC struct {
+	Format      string      `json:"format"`
+	Database    string      `json:"database"`
+	Created     time.Time   `json:"created"`
+	Manifest    string      `json:"manifest"`
+	Schema      string      `json:"schema"`
+	Before      string      `json:"before"`
+	PostActions string      `json:"post_actions"`
+	After       string      `json:"after"`
+	Tables      []fileEntry `json:"tables"`
+}
+
+// tarExt returns the extension of tar bundles with the given compression.
+func tarExt(compression string) string {
+	switch compression {
+	case TarCompressionGzip:
+		return ".tar.gz"
+	case TarCompressionZstd:
+		return ".tar.zst"
+	default:
+		return ".tar"
+	}
+}
+
+// dumpTar dumps the database as a tar bundle. Entries are written in a single pass, table data is spooled to a
+// temporary file in the spool directory first since tar headers need the entry size.
+func (d *dumper) dumpTar(ctx context.Context, w io.Writer) (err error) {
+	cw, err := d.tarCompressor(w)
+	if err != nil {
+		return err
+	}
+
+	// compressors hold resources until closed, e.g. goroutines of zstd encoders
+	defer func() {
+		if err != nil {
+			_ = cw.Close()
+		}
+	}()
+
+	info, err := d.db.GetInfo()
+	if err != nil {
+		return err
+	}
+
+	toc := tarTOC{
+		Format:      d.format,
+		Database:    info.Name,
+		Created:     d.now().UTC(),
+		Manifest:    tarManifestName,
+		Schema:      tarSchemaName,
+		Before:      tarBeforeName,
+		PostActions: tarPostActionsName,
+		After:       tarAfterName,
+		Tables:      make([]fileEntry, 0),
+	}
+
+	// tables are resolved first, toc and schema precede the data
+	tables := make([]*table, 0)
+
+	for d.nav.hasNext() {
+		t, err := d.nav.next()
+		if err != nil {
+			d.logger.Error("msg", "can't fetch next table", "error", err)
+
+			return err
+		}
+
+		columns, err := d.columnTypes(t)
+		if err != nil {
+			return err
+		}
+
+		tables = append(tables, t)
+		toc.Tables = append(toc.Tables, fileEntry{
+			Table: t.TableName, File: tarDataDir + t.TableName + ".sql", Columns: columns,
+			PreActions: t.PreActions, PostActions: t.afterLoad(),
+		})
+	}
+
+	tw := tar.NewWriter(cw)
+	bw := &tarBundleWriter{tw: tw, modTime: toc.Created, spoolDir: d.cfg.SpoolDir}
+
+	tocData, err := json.MarshalIndent(toc, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(tarTOCName, append(tocData, '\n')); err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(tarManifestName, d.manifest.source); err != nil {
+		return err
+	}
+
+	schema, err := d.tarSchema(tables)
+	if err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(tarSchemaName, schema); err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(tarBeforeName, actionsScript(d.hooks.before)); err != nil {
+		return err
+	}
+
+	var actions bytes.Buffer
+
+	for i, t := range tables {
+		t := t
+
+		err := bw.writeSpooled(toc.Tables[i].File, func(w io.Writer) error { return d.writeTarData(ctx, w, t) })
+		if err != nil {
+			d.logger.Error("msg", "failed to write table data", "table", t.TableName, "error", err)
+
+			return err
+		}
+
+		if err := writeActions(&actions, t.afterLoad()); err != nil {
+			return err
+		}
+	}
+
+	if err := bw.writeEntry(tarPostActionsName, actions.Bytes()); err != nil {
+		return err
+	}
+
+	if err := bw.writeEntry(tarAfterName, actionsScript(d.hooks.after)); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to close tar bundle")
+	}
+
+	return cw.Close()
+}
+
+// tarCompressor wraps the writer with the configured compression.
+func (d *dumper) tarCompressor(w io.Writer) (io.WriteCloser, error) {
+	switch d.cfg.TarCompression {
+	case TarCompressionGzip:
+		return gzip.NewWriter(w), nil
+	case TarCompressionZstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+// writeTarData writes table data as a psql script running the pre actions of the table and loading it with COPY.
+func (d *dumper) writeTarData(ctx context.Context, w io.Writer, t *table) error {
+	for _, action := range t.PreActions {
+		if _, err := fmt.Fprintf(w, "%s;\n\n", action); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "COPY %s (%s) FROM stdin;\n", t.TableName, quoteColumns(t.Columns)); err != nil {
+		return err
+	}
+
+	source, err := copyFrom(d.manifest, t)
+	if err != nil {
+		return err
+	}
+
+	if err := d.db.CopyTo(ctx, w, source); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, `\.`)
+
+	return err
+}
+
+// actionsScript returns the SQL script of the statements.
+func actionsScript(actions []string) []byte {
+	var script bytes.Buffer
+
+	// writes to a buffer don't fail
+	_ = writeActions(&script, actions)
+
+	return script.Bytes()
+}
+
+// tarSchema returns the script creating the dumped tables. Schemas are created before their first table, sequences of
+// serial columns with their table, and foreign keys are added after all tables are created.
+func (d *dumper) tarSchema(tables []*table) ([]byte, error) {
+	var schema, fks strings.Builder
+
+	fmt.Fprint(&schema, dumpSettings)
+
+	created := map[string]bool{"public": true}
+
+	createSchema := func(name string) {
+		if namespace, _ := splitTableName(name); !created[namespace] {
+			created[namespace] = true
+
+			fmt.Fprintf(&schema, "\n--\n-- Name: %s; Type: SCHEMA\n--\n\nCREATE SCHEMA IF NOT EXISTS %s;\n", namespace,
+				quoteIdent(namespace))
+		}
+	}
+
+	for _, t := range tables {
+		cols, err := d.db.GetTableDefinition(t.TableName)
+		if err != nil {
+			return nil, err
+		}
+
+		constraints, err := d.db.GetTableConstraints(t.TableName)
+		if err != nil {
+			return nil, err
+		}
+
+		createSchema(t.TableName)
+
+		fmt.Fprintf(&schema, "\n--\n-- Name: %s; Type: TABLE\n--\n\n", t.TableName)
+
+		lines := make([]string, 0, len(cols)+len(constraints))
+		owned := make([]string, 0)
+
+		for _, c := range cols {
+			// sequences of serial columns are created with the table and owned by their column
+			if seq := serialSequence(c.Default); seq != "" && c.Identity == "" && c.Generated == "" {
+				createSchema(seq)
+
+				fmt.Fprintf(&schema, "CREATE SEQUENCE IF NOT EXISTS %s;\n\n", seq)
+
+				owned = append(owned, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s;\n", seq, t.TableName,
+					quoteIdent(c.Name)))
+			}
+
+			lines = append(lines, "    "+columnDefinition(c))
+		}
+
+		for _, c := range constraints {
+			def := fmt.Sprintf("CONSTRAINT %s %s", quoteIdent(c.Name), c.Definition)
+
+			if c.Type == "f" {
+				fmt.Fprintf(&fks, "\nALTER TABLE ONLY %s ADD %s;\n", t.TableName, def)
+
+				continue
+			}
+
+			lines = append(lines, "    "+def)
+		}
+
+		fmt.Fprintf(&schema, "CREATE TABLE %s (\n%s\n);\n", t.TableName, strings.Join(lines, ",\n"))
+
+		for _, stmt := range owned {
+			schema.WriteString("\n" + stmt)
+		}
+	}
+
+	schema.WriteString(fks.String())
+
+	return []byte(schema.String()), nil
+}
+
+// columnDefinition returns the column definition in a CREATE TABLE statement.
+func columnDefinition(c database.ColumnDefinition) string {
+	def := quoteIdent(c.Name) + " " + c.Type
+
+	switch {
+	case c.Generated == "s":
+		return def + fmt.Sprintf(" GENERATED ALWAYS AS (%s) STORED", c.Default)
+	case c.Identity == "a":
+		def += " GENERATED ALWAYS AS IDENTITY"
+	case c.Identity == "d":
+		def += " GENERATED BY DEFAULT AS IDENTITY"
+	case c.Default != "":
+		def += " DEFAULT " + c.Default
+	}
+
+	if c.NotNull {
+		def += " NOT NULL"
+	}
+
+	return def
+}
+
+// serialSequence returns the sequence name of a nextval default expression, e.g. nextval('users_id_seq'::regclass).
+func serialSequence(expr string) string {
+	const prefix = "nextval('"
+
+	if !strings.HasPrefix(expr, prefix) {
+		return ""
+	}
+
+	end := strings.Index(expr[len(prefix):], "'::regclass)")
+	if end < 0 {
+		return ""
+	}
+
+	return expr[len(prefix) : len(prefix)+end]
+}
+
+// tarBundleWriter writes regular file entries to a tar bundle.
+type tarBundleWriter struct {
+	tw       *tar.Writer
+	modTime  time.Time
+	spoolDir string
+}
+
+// writeEntry writes an entry with the given content.
+func (b *tarBundleWriter) writeEntry(name string, data []byte) error {
+	if err := b.writeHeader(name, int64(len(data))); err != nil {
+		return err
+	}
+
+	_, err := b.tw.Write(data)
+
+	return err
+}
+
+// writeSpooled writes an entry with the content written by fn, the content is spooled to a temporary file to find
+// the entry size.
+func (b *tarBundleWriter) writeSpooled(name string, fn func(w io.Writer) error) error {
+	f, err := ioutil.TempFile(b.spoolDir, "pdd-*.tar-entry")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	if err := fn(f); err != nil {
+		return err
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := b.writeHeader(name, size); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(b.tw, f)
+
+	return err
+}
+
+func (b *tarBundleWriter) writeHeader(name string, size int64) error {
+	hdr := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0644, ModTime: b.modTime}
+
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return errors.Wrap(err, "failed to write tar header")
+	}
+
+	return nil
+}
+
+// nopWriteCloser is a writer with a no-op Close method.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
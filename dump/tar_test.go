@@ -0,0 +1,72 @@
+package dump
+
+import (
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/database"
+)
+
+func TestTarSchema(t *testing.T) {
+	db := &catalogDB{fakeDB: fakeDB{tables: map[string]fakeTable{
+		"users": {
+			columns: []database.ColumnDefinition{
+				{Name: "id", Type: "integer", NotNull: true, Default: "nextval('users_id_seq'::regclass)"},
+				{Name: `Full "Name"`, Type: "text"},
+			},
+			constraints: []database.Constraint{{Name: "users_pkey", Type: "p", Definition: "PRIMARY KEY (id)"}},
+		},
+		"sales.orders": {
+			columns: []database.ColumnDefinition{
+				{Name: "id", Type: "bigint", NotNull: true, Identity: "a"},
+				{Name: "user_id", Type: "integer"},
+			},
+			constraints: []database.Constraint{
+				{Name: "orders_user_id_fkey", Type: "f", Definition: "FOREIGN KEY (user_id) REFERENCES users(id)"},
+			},
+		},
+	}}}
+
+	d := &dumper{db: db}
+
+	schema, err := d.tarSchema([]*table{{TableName: "users"}, {TableName: "sales.orders"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := dumpSettings + `
+--
+-- Name: users; Type: TABLE
+--
+
+CREATE SEQUENCE IF NOT EXISTS users_id_seq;
+
+CREATE TABLE users (
+    "id" integer DEFAULT nextval('users_id_seq'::regclass) NOT NULL,
+    "Full ""Name""" text,
+    CONSTRAINT "users_pkey" PRIMARY KEY (id)
+);
+
+ALTER SEQUENCE users_id_seq OWNED BY users."id";
+
+--
+-- Name: sales; Type: SCHEMA
+--
+
+CREATE SCHEMA IF NOT EXISTS "sales";
+
+--
+-- Name: sales.orders; Type: TABLE
+--
+
+CREATE TABLE sales.orders (
+    "id" bigint GENERATED ALWAYS AS IDENTITY NOT NULL,
+    "user_id" integer
+);
+
+ALTER TABLE ONLY sales.orders ADD CONSTRAINT "orders_user_id_fkey" FOREIGN KEY (user_id) REFERENCES users(id);
+`
+
+	if string(schema) != want {
+		t.Fatalf("unexpected schema\n%s\nwant\n%s", schema, want)
+	}
+}
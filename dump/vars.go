@@ -0,0 +1,179 @@
+package dump
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aweris/postgres-data-dump/database"
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// prefixes of variable values read from a source.
+const (
+	varFilePrefix = "file:"
+	varExecPrefix = "exec:"
+)
+
+// envRef matches ${NAME} references to environment variables in variable values.
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolveVars overrides manifest variables with the given ones and resolves the values of all variables. Environment
+// variable references in strings are interpolated first, then strings with a source prefix are replaced by the content
+// of the source. Relative files of manifest variables are relative to their directory in dirs, the directory of the
+// manifest file defining them, and files of overrides are relative to the working directory.
+func resolveVars(
+	vars map[string]interface{}, overrides map[string]string, dirs map[string]string,
+) (map[string]interface{}, error) {
+	resolved := make(map[string]interface{}, len(vars)+len(overrides))
+
+	for k, v := range vars {
+		resolved[k] = v
+	}
+
+	for k, v := range overrides {
+		resolved[k] = v
+	}
+
+	// resolved in the order of names, so errors and executed commands are the same between runs
+	names := make([]string, 0, len(resolved))
+	for k := range resolved {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		base := dirs[name]
+		if _, ok := overrides[name]; ok {
+			base = ""
+		}
+
+		v, err := resolveValue(resolved[name], base)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve variable %q", name)
+		}
+
+		resolved[name] = v
+	}
+
+	return resolved, nil
+}
+
+// resolveValue resolves strings in the variable value, dates and times are converted to time variables.
+func resolveValue(value interface{}, dir string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return resolveVar(v, dir)
+	case time.Time:
+		return timeVar{v}, nil
+	case []interface{}:
+		items := make([]interface{}, 0, len(v))
+
+		for _, item := range v {
+			resolved, err := resolveValue(item, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, resolved)
+		}
+
+		return items, nil
+	default:
+		return value, nil
+	}
+}
+
+// resolveVar resolves a string variable value, relative files are relative to dir.
+func resolveVar(value, dir string) (string, error) {
+	var missing string
+
+	value = envRef.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+
+		v, ok := os.LookupEnv(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+
+		return v
+	})
+
+	if missing != "" {
+		return "", errors.Wrap(ErrUndefinedEnv, missing)
+	}
+
+	switch {
+	case strings.HasPrefix(value, varFilePrefix):
+		data, err := ioutil.ReadFile(resolvePath(dir, strings.TrimPrefix(value, varFilePrefix)))
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read variable file")
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, varExecPrefix):
+		var stdout, stderr bytes.Buffer
+
+		cmd := exec.Command("sh", "-c", strings.TrimPrefix(value, varExecPrefix))
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+		if err := cmd.Run(); err != nil {
+			return "", errors.Wrapf(err, "failed to execute variable command: %s", strings.TrimSpace(stderr.String()))
+		}
+
+		return strings.TrimRight(stdout.String(), "\r\n"), nil
+	default:
+		return value, nil
+	}
+}
+
+// queryVars sets the variables of the variable queries to their results, in the order of their names. Queries are
+// rendered with the variables set so far, and variables given by overrides are not queried.
+func (m *manifest) queryVars(logger log.Logger, db database.DB, overrides map[string]string) error {
+	names := make([]string, 0, len(m.VarsFromQuery))
+	for k := range m.VarsFromQuery {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := overrides[name]; ok {
+			continue
+		}
+
+		vq := m.VarsFromQuery[name]
+
+		query, err := renderQuery(m, vq.Query)
+		if err != nil {
+			return errors.Wrapf(err, "failed to render query of variable %q", name)
+		}
+
+		values, err := db.GetQueryValues(query)
+		if err != nil {
+			return errors.Wrapf(err, "failed to query variable %q", name)
+		}
+
+		logger.Debug("msg", "query variable", "name", name, "rows", len(values))
+
+		switch {
+		case vq.List:
+			m.Vars[name] = values
+		case len(values) == 0:
+			m.Vars[name] = nil
+		case len(values) == 1:
+			m.Vars[name] = values[0]
+		default:
+			return errors.Wrapf(ErrNotSingleValue, "query of variable %q returned %d rows", name, len(values))
+		}
+	}
+
+	return nil
+}
@@ -6,6 +6,7 @@ require (
 	github.com/go-kit/kit v0.10.0
 	github.com/go-pg/pg/extra/pgotel v0.1.0
 	github.com/go-pg/pg/v10 v10.6.2
+	github.com/klauspost/compress v1.11.13
 	github.com/pkg/errors v0.8.1
 	github.com/spf13/pflag v1.0.5
 	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
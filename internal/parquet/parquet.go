@@ -0,0 +1,625 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Kind is the kind of values stored in a column.
+type Kind int
+
+// Column kinds and the Go types of their values.
+const (
+	KindString         Kind = iota // string
+	KindBytes                      // []byte
+	KindBoolean                    // bool
+	KindInt16                      // int32
+	KindInt32                      // int32
+	KindInt64                      // int64
+	KindFloat                      // float32
+	KindDouble                     // float64
+	KindDecimal                    // *big.Int, unscaled value
+	KindDate                       // int32, days since unix epoch
+	KindTimestamp                  // int64, microseconds since unix epoch in UTC
+	KindLocalTimestamp             // int64, microseconds since unix epoch without time zone
+)
+
+// Compression codecs.
+const (
+	CompressionNone   = "none"
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// parquet physical types.
+const (
+	typeBoolean           = 0
+	typeInt32             = 1
+	typeInt64             = 2
+	typeFloat             = 4
+	typeDouble            = 5
+	typeByteArray         = 6
+	typeFixedLenByteArray = 7
+)
+
+// parquet converted types, written for the readers not supporting logical types.
+const (
+	convertedUTF8            = 0
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMicros = 10
+	convertedInt16           = 16
+)
+
+// other parquet constants.
+const (
+	magic = "PAR1"
+
+	repetitionOptional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecZstd         = 6
+
+	pageTypeData = 0
+
+	// maximum precision of decimals stored as int64.
+	maxInt64Precision = 18
+)
+
+// Column describes a column of the file, all columns are nullable.
+type Column struct {
+	Name string
+	Kind Kind
+
+	// Precision and Scale are used by KindDecimal.
+	Precision int
+	Scale     int
+}
+
+// Writer writes rows as a parquet file. Rows are buffered until the row group is full, then written as a row group
+// having a single page per column.
+type Writer struct {
+	w        *countingWriter
+	columns  []Column
+	codec    int32
+	compress func([]byte) ([]byte, error)
+	groupLen int
+
+	chunks    []*chunk
+	rows      int
+	totalRows int64
+	groups    []rowGroup
+}
+
+// chunk buffers the values of a column in the current row group.
+type chunk struct {
+	levels []byte // definition levels, 0 for null
+	values bytes.Buffer
+	bools  []bool
+	count  int
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	size    int64
+	rows    int64
+	offset  int64
+}
+
+type columnChunk struct {
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// NewWriter creates a Writer with the given columns, compression codec and number of rows per row group.
+func NewWriter(w io.Writer, columns []Column, compression string, groupLen int) (*Writer, error) {
+	pw := &Writer{w: &countingWriter{w: w}, columns: columns, groupLen: groupLen}
+
+	switch compression {
+	case CompressionNone:
+		pw.codec, pw.compress = codecUncompressed, func(b []byte) ([]byte, error) { return b, nil }
+	case CompressionSnappy:
+		pw.codec, pw.compress = codecSnappy, func(b []byte) ([]byte, error) { return snappy.Encode(nil, b), nil }
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		pw.codec, pw.compress = codecZstd, func(b []byte) ([]byte, error) { return enc.EncodeAll(b, nil), nil }
+	default:
+		return nil, errors.Errorf("unknown parquet compression %s", compression)
+	}
+
+	if groupLen <= 0 {
+		return nil, errors.Errorf("invalid row group size %d", groupLen)
+	}
+
+	for _, c := range columns {
+		if c.Kind == KindDecimal && (c.Precision <= 0 || c.Scale < 0 || c.Scale > c.Precision) {
+			return nil, errors.Errorf("invalid decimal precision and scale of %s", c.Name)
+		}
+	}
+
+	pw.reset()
+
+	if _, err := pw.w.Write([]byte(magic)); err != nil {
+		return nil, err
+	}
+
+	return pw, nil
+}
+
+// Write buffers the row, values must be in column order and have the Go type of the column kind or be nil.
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return errors.Errorf("row has %d values, expected %d", len(row), len(w.columns))
+	}
+
+	for i, v := range row {
+		if err := w.chunks[i].add(w.columns[i], v); err != nil {
+			return errors.Wrapf(err, "column %s", w.columns[i].Name)
+		}
+	}
+
+	w.rows++
+
+	if w.rows >= w.groupLen {
+		return w.flush()
+	}
+
+	return nil
+}
+
+// Close writes remaining rows and the file footer. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	if w.rows > 0 {
+		if err := w.flush(); err != nil {
+			return err
+		}
+	}
+
+	footer := w.fileMetadata()
+
+	if _, err := w.w.Write(footer); err != nil {
+		return err
+	}
+
+	var size [4]byte
+
+	binary.LittleEndian.PutUint32(size[:], uint32(len(footer)))
+
+	if _, err := w.w.Write(size[:]); err != nil {
+		return err
+	}
+
+	_, err := w.w.Write([]byte(magic))
+
+	return err
+}
+
+func (w *Writer) reset() {
+	w.chunks = make([]*chunk, len(w.columns))
+	for i := range w.chunks {
+		w.chunks[i] = &chunk{}
+	}
+
+	w.rows = 0
+}
+
+// flush writes buffered rows as a row group.
+func (w *Writer) flush() error {
+	g := rowGroup{rows: int64(w.rows), offset: w.w.n}
+
+	for i, c := range w.chunks {
+		cc, err := w.writePage(w.columns[i], c)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write column %s", w.columns[i].Name)
+		}
+
+		g.columns = append(g.columns, cc)
+		g.size += cc.uncompressed
+	}
+
+	w.groups = append(w.groups, g)
+	w.totalRows += g.rows
+	w.reset()
+
+	return nil
+}
+
+// writePage writes the column chunk as a single data page.
+func (w *Writer) writePage(col Column, c *chunk) (columnChunk, error) {
+	var body bytes.Buffer
+
+	// definition levels, prefixed with their length
+	levels := encodeLevels(c.levels)
+
+	var size [4]byte
+
+	binary.LittleEndian.PutUint32(size[:], uint32(len(levels)))
+	body.Write(size[:])
+	body.Write(levels)
+
+	if col.Kind == KindBoolean {
+		body.Write(packBools(c.bools))
+	} else {
+		body.Write(c.values.Bytes())
+	}
+
+	compressed, err := w.compress(body.Bytes())
+	if err != nil {
+		return columnChunk{}, err
+	}
+
+	t := &thriftWriter{}
+	t.i32(1, pageTypeData)
+	t.i32(2, int32(body.Len()))
+	t.i32(3, int32(len(compressed)))
+	t.structBegin(5)
+	t.i32(1, int32(len(c.levels)))
+	t.i32(2, encodingPlain)
+	t.i32(3, encodingRLE)
+	t.i32(4, encodingRLE)
+	t.structEnd()
+
+	header := t.end()
+	offset := w.w.n
+
+	if _, err := w.w.Write(header); err != nil {
+		return columnChunk{}, err
+	}
+
+	if _, err := w.w.Write(compressed); err != nil {
+		return columnChunk{}, err
+	}
+
+	return columnChunk{
+		offset:       offset,
+		values:       int64(len(c.levels)),
+		uncompressed: int64(len(header) + body.Len()),
+		compressed:   int64(len(header) + len(compressed)),
+	}, nil
+}
+
+// fileMetadata encodes the file footer.
+func (w *Writer) fileMetadata() []byte {
+	t := &thriftWriter{}
+	t.i32(1, 1)
+
+	// schema, root element followed by the columns
+	t.listBegin(2, thriftStruct, len(w.columns)+1)
+	t.listStructBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(w.columns)))
+	t.structEnd()
+
+	for _, c := range w.columns {
+		t.listStructBegin()
+		writeSchemaElement(t, c)
+		t.structEnd()
+	}
+
+	t.i64(3, w.totalRows)
+
+	t.listBegin(4, thriftStruct, len(w.groups))
+
+	for _, g := range w.groups {
+		t.listStructBegin()
+		t.listBegin(1, thriftStruct, len(g.columns))
+
+		var compressed int64
+
+		for i, cc := range g.columns {
+			compressed += cc.compressed
+
+			t.listStructBegin()
+			t.i64(2, cc.offset)
+			t.structBegin(3)
+			t.i32(1, physicalType(w.columns[i]))
+			t.listBegin(2, thriftI32, 2)
+			t.listI32(encodingPlain)
+			t.listI32(encodingRLE)
+			t.listBegin(3, thriftBinary, 1)
+			t.listBinary(w.columns[i].Name)
+			t.i32(4, w.codec)
+			t.i64(5, cc.values)
+			t.i64(6, cc.uncompressed)
+			t.i64(7, cc.compressed)
+			t.i64(9, cc.offset)
+			t.structEnd()
+			t.structEnd()
+		}
+
+		t.i64(2, g.size)
+		t.i64(3, g.rows)
+		t.i64(5, g.offset)
+		t.i64(6, compressed)
+		t.structEnd()
+	}
+
+	t.binary(6, "pdd")
+
+	return t.end()
+}
+
+// writeSchemaElement writes the schema element of the column.
+func writeSchemaElement(t *thriftWriter, c Column) {
+	typ := physicalType(c)
+
+	t.i32(1, typ)
+
+	if typ == typeFixedLenByteArray {
+		t.i32(2, int32(decimalLength(c.Precision)))
+	}
+
+	t.i32(3, repetitionOptional)
+	t.binary(4, c.Name)
+
+	switch c.Kind {
+	case KindString:
+		t.i32(6, convertedUTF8)
+		t.structBegin(10)
+		t.structBegin(1) // STRING
+		t.structEnd()
+		t.structEnd()
+	case KindInt16:
+		t.i32(6, convertedInt16)
+		t.structBegin(10)
+		t.structBegin(10) // INTEGER
+		t.i8(1, 16)
+		t.bool(2, true)
+		t.structEnd()
+		t.structEnd()
+	case KindDecimal:
+		t.i32(6, convertedDecimal)
+		t.i32(7, int32(c.Scale))
+		t.i32(8, int32(c.Precision))
+		t.structBegin(10)
+		t.structBegin(5) // DECIMAL
+		t.i32(1, int32(c.Scale))
+		t.i32(2, int32(c.Precision))
+		t.structEnd()
+		t.structEnd()
+	case KindDate:
+		t.i32(6, convertedDate)
+		t.structBegin(10)
+		t.structBegin(6) // DATE
+		t.structEnd()
+		t.structEnd()
+	case KindTimestamp, KindLocalTimestamp:
+		// converted type implies UTC adjusted timestamps
+		if c.Kind == KindTimestamp {
+			t.i32(6, convertedTimestampMicros)
+		}
+
+		t.structBegin(10)
+		t.structBegin(8) // TIMESTAMP
+		t.bool(1, c.Kind == KindTimestamp)
+		t.structBegin(2) // unit
+		t.structBegin(2) // MICROS
+		t.structEnd()
+		t.structEnd()
+		t.structEnd()
+		t.structEnd()
+	}
+}
+
+// physicalType returns the parquet type used to store the column.
+func physicalType(c Column) int32 {
+	switch c.Kind {
+	case KindBoolean:
+		return typeBoolean
+	case KindInt16, KindInt32, KindDate:
+		return typeInt32
+	case KindInt64, KindTimestamp, KindLocalTimestamp:
+		return typeInt64
+	case KindFloat:
+		return typeFloat
+	case KindDouble:
+		return typeDouble
+	case KindDecimal:
+		if c.Precision <= maxInt64Precision {
+			return typeInt64
+		}
+
+		return typeFixedLenByteArray
+	default:
+		return typeByteArray
+	}
+}
+
+// add appends the value to the chunk.
+func (c *chunk) add(col Column, v interface{}) error {
+	c.count++
+
+	if v == nil {
+		c.levels = append(c.levels, 0)
+		return nil
+	}
+
+	c.levels = append(c.levels, 1)
+
+	var buf [8]byte
+
+	switch col.Kind {
+	case KindString:
+		s, ok := v.(string)
+		if !ok {
+			return typeError(v, "string")
+		}
+
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(s)))
+		c.values.Write(buf[:4])
+		c.values.WriteString(s)
+	case KindBytes:
+		b, ok := v.([]byte)
+		if !ok {
+			return typeError(v, "[]byte")
+		}
+
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(b)))
+		c.values.Write(buf[:4])
+		c.values.Write(b)
+	case KindBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return typeError(v, "bool")
+		}
+
+		c.bools = append(c.bools, b)
+	case KindInt16, KindInt32, KindDate:
+		i, ok := v.(int32)
+		if !ok {
+			return typeError(v, "int32")
+		}
+
+		binary.LittleEndian.PutUint32(buf[:4], uint32(i))
+		c.values.Write(buf[:4])
+	case KindInt64, KindTimestamp, KindLocalTimestamp:
+		i, ok := v.(int64)
+		if !ok {
+			return typeError(v, "int64")
+		}
+
+		binary.LittleEndian.PutUint64(buf[:], uint64(i))
+		c.values.Write(buf[:])
+	case KindFloat:
+		f, ok := v.(float32)
+		if !ok {
+			return typeError(v, "float32")
+		}
+
+		binary.LittleEndian.PutUint32(buf[:4], math.Float32bits(f))
+		c.values.Write(buf[:4])
+	case KindDouble:
+		f, ok := v.(float64)
+		if !ok {
+			return typeError(v, "float64")
+		}
+
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		c.values.Write(buf[:])
+	case KindDecimal:
+		d, ok := v.(*big.Int)
+		if !ok {
+			return typeError(v, "*big.Int")
+		}
+
+		return c.addDecimal(col, d)
+	}
+
+	return nil
+}
+
+// addDecimal appends unscaled decimal value as int64 or big endian two's complement fixed length bytes.
+func (c *chunk) addDecimal(col Column, d *big.Int) error {
+	if col.Precision <= maxInt64Precision {
+		if !d.IsInt64() {
+			return errors.Errorf("decimal %s overflows precision %d", d, col.Precision)
+		}
+
+		var buf [8]byte
+
+		binary.LittleEndian.PutUint64(buf[:], uint64(d.Int64()))
+		c.values.Write(buf[:])
+
+		return nil
+	}
+
+	n := decimalLength(col.Precision)
+
+	// two's complement of negative values
+	v := new(big.Int).Set(d)
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), uint(n*8)))
+	}
+
+	b := v.Bytes()
+	if len(b) > n {
+		return errors.Errorf("decimal %s overflows precision %d", d, col.Precision)
+	}
+
+	pad := byte(0)
+	if d.Sign() < 0 {
+		pad = 0xff
+	}
+
+	for i := len(b); i < n; i++ {
+		c.values.WriteByte(pad)
+	}
+
+	c.values.Write(b)
+
+	return nil
+}
+
+// decimalLength returns the minimum number of bytes to store decimals of the given precision.
+func decimalLength(precision int) int {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+
+	// one bit for the sign
+	return (max.BitLen() + 1 + 7) / 8
+}
+
+// encodeLevels encodes definition levels with bit width 1 as bit packed runs of RLE/bit-packing hybrid encoding.
+func encodeLevels(levels []byte) []byte {
+	groups := (len(levels) + 7) / 8
+
+	var tmp [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(tmp[:], uint64(groups<<1|1))
+
+	out := append([]byte(nil), tmp[:n]...)
+	packed := make([]byte, groups)
+
+	for i, l := range levels {
+		packed[i/8] |= l << (uint(i) % 8)
+	}
+
+	return append(out, packed...)
+}
+
+// packBools packs booleans, least significant bit first.
+func packBools(values []bool) []byte {
+	packed := make([]byte, (len(values)+7)/8)
+
+	for i, v := range values {
+		if v {
+			packed[i/8] |= 1 << (uint(i) % 8)
+		}
+	}
+
+	return packed
+}
+
+func typeError(v interface{}, expected string) error {
+	return fmt.Errorf("unexpected value type %T, expected %s", v, expected)
+}
+
+// countingWriter counts written bytes to resolve offsets in the file.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,489 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// thriftReader is a minimal thrift compact protocol decoder, structs are decoded into maps by field id.
+type thriftReader struct {
+	t   *testing.T
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) read(n int) []byte {
+	if n < 0 || r.pos+n > len(r.buf) {
+		r.t.Fatalf("thrift: read %d bytes at %d, out of %d", n, r.pos, len(r.buf))
+	}
+
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+
+	return b
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("thrift: invalid varint at %d", r.pos)
+	}
+
+	r.pos += n
+
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftBooleanTrue:
+		return true
+	case thriftBooleanFalse:
+		return false
+	case thriftByte:
+		return int64(int8(r.read(1)[0]))
+	case thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		return string(r.read(int(r.varint())))
+	case thriftList:
+		header := r.read(1)[0]
+
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.varint())
+		}
+
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(header & 0x0f)
+		}
+
+		return list
+	case thriftStruct:
+		return r.structure()
+	default:
+		r.t.Fatalf("thrift: unexpected type %d at %d", typ, r.pos)
+		return nil
+	}
+}
+
+func (r *thriftReader) structure() thriftFields {
+	fields := make(thriftFields)
+
+	var id int16
+
+	for {
+		header := r.read(1)[0]
+		if header == 0 {
+			return fields
+		}
+
+		if delta := int16(header >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+
+		fields[id] = r.value(header & 0x0f)
+	}
+}
+
+// thriftFields is a decoded struct, values are int64, bool, string, []interface{} or thriftFields.
+type thriftFields map[int16]interface{}
+
+func (s thriftFields) int(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s thriftFields) str(id int16) string {
+	v, _ := s[id].(string)
+	return v
+}
+
+func (s thriftFields) child(id int16) thriftFields {
+	v, _ := s[id].(thriftFields)
+	return v
+}
+
+func (s thriftFields) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+// readFile decodes the parquet file into its footer and rows, checking the structure written by the Writer.
+func readFile(t *testing.T, data []byte, columns []Column) (thriftFields, [][]interface{}) {
+	if len(data) < 12 || string(data[:4]) != magic || string(data[len(data)-4:]) != magic {
+		t.Fatal("missing magic")
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := (&thriftReader{t: t, buf: data[len(data)-8-size : len(data)-8]}).structure()
+
+	rows := make([][]interface{}, 0)
+
+	for _, g := range footer.list(4) {
+		group := g.(thriftFields)
+
+		values := make([][]interface{}, len(columns))
+
+		for i, c := range group.list(1) {
+			meta := c.(thriftFields).child(3)
+
+			if path := meta.list(3); len(path) != 1 || path[0] != columns[i].Name {
+				t.Fatalf("unexpected path %v of column %s", path, columns[i].Name)
+			}
+
+			if meta.int(1) != int64(physicalType(columns[i])) {
+				t.Fatalf("unexpected type %d of column %s", meta.int(1), columns[i].Name)
+			}
+
+			values[i] = readChunk(t, data, meta, columns[i])
+
+			if int64(len(values[i])) != group.int(3) {
+				t.Fatalf("column %s has %d values, expected %d", columns[i].Name, len(values[i]), group.int(3))
+			}
+		}
+
+		for j := int64(0); j < group.int(3); j++ {
+			row := make([]interface{}, len(columns))
+			for i := range columns {
+				row[i] = values[i][j]
+			}
+
+			rows = append(rows, row)
+		}
+	}
+
+	if footer.int(3) != int64(len(rows)) {
+		t.Fatalf("footer has %d rows, read %d", footer.int(3), len(rows))
+	}
+
+	return footer, rows
+}
+
+// readChunk decodes the single data page of the column chunk.
+func readChunk(t *testing.T, data []byte, meta thriftFields, col Column) []interface{} {
+	r := &thriftReader{t: t, buf: data, pos: int(meta.int(9))}
+	header := r.structure()
+
+	if header.int(1) != pageTypeData {
+		t.Fatalf("unexpected page type %d", header.int(1))
+	}
+
+	if int64(r.pos)-meta.int(9)+header.int(3) != meta.int(7) {
+		t.Fatalf("compressed size of %s doesn't match its page", col.Name)
+	}
+
+	page := r.read(int(header.int(3)))
+
+	var err error
+
+	switch meta.int(4) {
+	case codecSnappy:
+		page, err = snappy.Decode(nil, page)
+	case codecZstd:
+		dec, _ := zstd.NewReader(nil)
+		page, err = dec.DecodeAll(page, nil)
+		dec.Close()
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int64(len(page)) != header.int(2) {
+		t.Fatalf("uncompressed size of %s is %d, expected %d", col.Name, len(page), header.int(2))
+	}
+
+	n := int(header.child(5).int(1))
+	if int64(n) != meta.int(5) {
+		t.Fatalf("page of %s has %d values, expected %d", col.Name, n, meta.int(5))
+	}
+
+	pr := &thriftReader{t: t, buf: page}
+	levels := decodeLevels(t, pr.read(int(binary.LittleEndian.Uint32(pr.read(4)))), n)
+
+	values := make([]interface{}, n)
+	bools := 0
+
+	for i, l := range levels {
+		if l == 0 {
+			continue
+		}
+
+		switch col.Kind {
+		case KindString:
+			values[i] = string(pr.read(int(binary.LittleEndian.Uint32(pr.read(4)))))
+		case KindBytes:
+			values[i] = append([]byte(nil), pr.read(int(binary.LittleEndian.Uint32(pr.read(4))))...)
+		case KindBoolean:
+			values[i] = page[pr.pos+bools/8]&(1<<(uint(bools)%8)) != 0
+			bools++
+		case KindInt16, KindInt32, KindDate:
+			values[i] = int32(binary.LittleEndian.Uint32(pr.read(4)))
+		case KindInt64, KindTimestamp, KindLocalTimestamp:
+			values[i] = int64(binary.LittleEndian.Uint64(pr.read(8)))
+		case KindFloat:
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(pr.read(4)))
+		case KindDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(pr.read(8)))
+		case KindDecimal:
+			if col.Precision <= maxInt64Precision {
+				values[i] = big.NewInt(int64(binary.LittleEndian.Uint64(pr.read(8))))
+				continue
+			}
+
+			b := pr.read(decimalLength(col.Precision))
+			v := new(big.Int).SetBytes(b)
+
+			if b[0]&0x80 != 0 {
+				v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+			}
+
+			values[i] = v
+		}
+	}
+
+	if col.Kind == KindBoolean {
+		pr.read((bools + 7) / 8)
+	}
+
+	if pr.pos != len(page) {
+		t.Fatalf("page of %s has %d trailing bytes", col.Name, len(page)-pr.pos)
+	}
+
+	return values
+}
+
+// decodeLevels decodes n definition levels of bit width 1 in RLE/bit-packing hybrid encoding.
+func decodeLevels(t *testing.T, data []byte, n int) []byte {
+	r := &thriftReader{t: t, buf: data}
+	levels := make([]byte, 0, n)
+
+	for r.pos < len(data) {
+		header := r.varint()
+
+		if header&1 == 0 {
+			// repeated value
+			v := r.read(1)[0]
+			for i := uint64(0); i < header>>1; i++ {
+				levels = append(levels, v)
+			}
+
+			continue
+		}
+
+		for _, b := range r.read(int(header >> 1)) {
+			for i := uint(0); i < 8; i++ {
+				levels = append(levels, b>>i&1)
+			}
+		}
+	}
+
+	if len(levels) < n {
+		t.Fatalf("decoded %d levels, expected %d", len(levels), n)
+	}
+
+	return levels[:n]
+}
+
+func testColumns() []Column {
+	return []Column{
+		{Name: "s", Kind: KindString},
+		{Name: "b", Kind: KindBytes},
+		{Name: "ok", Kind: KindBoolean},
+		{Name: "i16", Kind: KindInt16},
+		{Name: "i32", Kind: KindInt32},
+		{Name: "i64", Kind: KindInt64},
+		{Name: "f", Kind: KindFloat},
+		{Name: "d", Kind: KindDouble},
+		{Name: "dec", Kind: KindDecimal, Precision: 10, Scale: 2},
+		{Name: "bigdec", Kind: KindDecimal, Precision: 38, Scale: 4},
+		{Name: "day", Kind: KindDate},
+		{Name: "ts", Kind: KindTimestamp},
+		{Name: "lts", Kind: KindLocalTimestamp},
+	}
+}
+
+func testRows(n int) [][]interface{} {
+	huge, _ := new(big.Int).SetString("-12345678901234567890123456789012345678", 10)
+
+	rows := make([][]interface{}, 0, n)
+
+	for i := 0; i < n; i++ {
+		row := []interface{}{
+			"row " + string(rune('a'+i%26)) + " ü",
+			[]byte{byte(i), 0, 0xff},
+			i%3 == 0,
+			int32(-i),
+			int32(i * 1000),
+			int64(i) << 40,
+			float32(i) / 3,
+			-float64(i) / 7,
+			big.NewInt(int64(i*12345) - 5000),
+			new(big.Int).Add(huge, big.NewInt(int64(i))),
+			int32(19000 + i),
+			int64(1700000000000000 + i),
+			int64(-i),
+		}
+
+		// nulls in a different column of each row, and a row of nulls
+		if i == 5 {
+			for j := range row {
+				row[j] = nil
+			}
+		} else {
+			row[i%len(row)] = nil
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func equalValues(a, b interface{}) bool {
+	if x, ok := a.(*big.Int); ok {
+		y, ok := b.(*big.Int)
+		return ok && x.Cmp(y) == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+func TestRoundTrip(t *testing.T) {
+	columns := testColumns()
+	rows := testRows(20)
+
+	for _, compression := range []string{CompressionNone, CompressionSnappy, CompressionZstd} {
+		compression := compression
+
+		t.Run(compression, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			// 20 rows in groups of 8 rows, the last group is written on close
+			w, err := NewWriter(&buf, columns, compression, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, row := range rows {
+				if err := w.Write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			footer, got := readFile(t, buf.Bytes(), columns)
+
+			if groups := len(footer.list(4)); groups != 3 {
+				t.Fatalf("unexpected number of row groups %d", groups)
+			}
+
+			if len(got) != len(rows) {
+				t.Fatalf("read %d rows, expected %d", len(got), len(rows))
+			}
+
+			for i := range rows {
+				for j := range columns {
+					if !equalValues(got[i][j], rows[i][j]) {
+						t.Errorf("row %d column %s: %v, expected %v", i, columns[j].Name, got[i][j], rows[i][j])
+					}
+				}
+			}
+
+			schema := footer.list(2)
+			if len(schema) != len(columns)+1 || schema[0].(thriftFields).int(5) != int64(len(columns)) {
+				t.Fatalf("unexpected schema %v", schema)
+			}
+
+			for i, c := range columns {
+				e := schema[i+1].(thriftFields)
+
+				if e.str(4) != c.Name || e.int(1) != int64(physicalType(c)) || e.int(3) != repetitionOptional {
+					t.Errorf("unexpected schema element %v of %s", e, c.Name)
+				}
+			}
+
+			// decimals keep their scale and precision, large ones are stored in fixed length arrays
+			dec := schema[10].(thriftFields)
+			if dec.int(2) != int64(decimalLength(38)) || dec.int(7) != 4 || dec.int(8) != 38 {
+				t.Errorf("unexpected schema element of decimal %v", dec)
+			}
+
+			if logical := dec.child(10).child(5); !reflect.DeepEqual(logical, thriftFields{1: int64(4), 2: int64(38)}) {
+				t.Errorf("unexpected logical type of decimal %v", logical)
+			}
+		})
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, testColumns(), CompressionSnappy, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	footer, rows := readFile(t, buf.Bytes(), testColumns())
+	if len(rows) != 0 || len(footer.list(4)) != 0 {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		column Column
+		value  interface{}
+	}{
+		{name: "string type", column: Column{Name: "c", Kind: KindString}, value: 1},
+		{name: "int32 type", column: Column{Name: "c", Kind: KindInt32}, value: int64(1)},
+		{name: "decimal overflow", column: Column{Name: "c", Kind: KindDecimal, Precision: 4},
+			value: new(big.Int).Lsh(big.NewInt(1), 64)},
+		{name: "fixed decimal overflow", column: Column{Name: "c", Kind: KindDecimal, Precision: 20},
+			value: new(big.Int).Lsh(big.NewInt(1), 80)},
+	}
+
+	for _, tt := range tests {
+		w, err := NewWriter(&bytes.Buffer{}, []Column{tt.column}, CompressionNone, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := w.Write([]interface{}{tt.value}); err == nil {
+			t.Errorf("%s: write succeeded", tt.name)
+		}
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, []Column{{Name: "c", Kind: KindDecimal, Precision: 2, Scale: 3}},
+		CompressionNone, 10); err == nil {
+		t.Error("decimal scale larger than precision accepted")
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, nil, "lz4", 10); err == nil {
+		t.Error("unknown compression accepted")
+	}
+}
@@ -0,0 +1,132 @@
+package parquet
+
+import (
+	"encoding/binary"
+)
+
+// thrift compact protocol types.
+const (
+	thriftBooleanTrue  = 1
+	thriftBooleanFalse = 2
+	thriftByte         = 3
+	thriftI32          = 5
+	thriftI64          = 6
+	thriftBinary       = 8
+	thriftList         = 9
+	thriftStruct       = 12
+)
+
+// thriftWriter is a minimal thrift compact protocol encoder, sufficient to write parquet metadata.
+type thriftWriter struct {
+	buf    []byte
+	last   int16
+	parent []int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+
+	n := binary.PutUvarint(tmp[:], v)
+	t.buf = append(t.buf, tmp[:n]...)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.zigzag(int64(id))
+	}
+
+	t.last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) i8(id int16, v int8) {
+	t.fieldHeader(id, thriftByte)
+	t.buf = append(t.buf, byte(v))
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.fieldHeader(id, thriftBooleanTrue)
+	} else {
+		t.fieldHeader(id, thriftBooleanFalse)
+	}
+}
+
+func (t *thriftWriter) binary(id int16, v string) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
+
+// structBegin starts a struct field, fields written until structEnd belong to the struct.
+func (t *thriftWriter) structBegin(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.push()
+}
+
+// structEnd ends the current struct.
+func (t *thriftWriter) structEnd() {
+	t.buf = append(t.buf, 0)
+	t.pop()
+}
+
+// listBegin starts a list field of the given element type and size.
+func (t *thriftWriter) listBegin(id int16, typ byte, size int) {
+	t.fieldHeader(id, thriftList)
+
+	if size < 15 {
+		t.buf = append(t.buf, byte(size)<<4|typ)
+	} else {
+		t.buf = append(t.buf, 0xf0|typ)
+		t.varint(uint64(size))
+	}
+}
+
+// listI32 writes i32 list element.
+func (t *thriftWriter) listI32(v int32) {
+	t.zigzag(int64(v))
+}
+
+// listBinary writes binary list element.
+func (t *thriftWriter) listBinary(v string) {
+	t.varint(uint64(len(v)))
+	t.buf = append(t.buf, v...)
+}
+
+// listStructBegin starts a struct list element.
+func (t *thriftWriter) listStructBegin() {
+	t.push()
+}
+
+func (t *thriftWriter) push() {
+	t.parent = append(t.parent, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) pop() {
+	t.last = t.parent[len(t.parent)-1]
+	t.parent = t.parent[:len(t.parent)-1]
+}
+
+// end terminates the top level struct.
+func (t *thriftWriter) end() []byte {
+	t.buf = append(t.buf, 0)
+
+	return t.buf
+}
@@ -0,0 +1,178 @@
+package sqlite
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// b-tree page types.
+const (
+	pageInteriorIndex = 0x02
+	pageInteriorTable = 0x05
+	pageLeafIndex     = 0x0a
+	pageLeafTable     = 0x0d
+)
+
+// cell is a b-tree cell. Interior cells point to the child page holding the keys up to the cell key.
+type cell struct {
+	child uint32
+	// body is the cell content following the child page number
+	body []byte
+	// divider is the body of the interior cell pointing to the page ending with this cell
+	divider []byte
+}
+
+// btree builds a b-tree bottom up from cells added in key order. Full pages are written immediately, parent levels
+// are kept in memory until the tree is finished.
+type btree struct {
+	w      *Writer
+	index  bool
+	levels []*level
+	// capacity is the space for cells and cell pointers in a page
+	capacity int
+}
+
+type level struct {
+	cells []cell
+	size  int
+}
+
+func newBTree(w *Writer, index bool, capacity int) *btree {
+	return &btree{w: w, index: index, levels: []*level{{}}, capacity: capacity}
+}
+
+// add adds a leaf cell.
+func (b *btree) add(c cell) error {
+	return b.addAt(0, c)
+}
+
+func (b *btree) addAt(depth int, c cell) error {
+	l := b.levels[depth]
+	size := len(c.body) + 2
+
+	if depth > 0 {
+		size += 4
+	}
+
+	if size > b.capacity {
+		return errors.New("cell exceeds page size")
+	}
+
+	if l.size+size > b.capacity {
+		// table leaves keep all rows, other pages give their last cell to the parent as divider
+		cells, right := l.cells, l.cells[len(l.cells)-1]
+		if depth > 0 || b.index {
+			cells = cells[:len(cells)-1]
+		}
+
+		page, err := b.writePage(depth, cells, right.child, 0)
+		if err != nil {
+			return err
+		}
+
+		if depth+1 == len(b.levels) {
+			b.levels = append(b.levels, &level{})
+		}
+
+		if err := b.addAt(depth+1, cell{child: page, body: right.divider, divider: right.divider}); err != nil {
+			return err
+		}
+
+		l.cells, l.size = nil, 0
+	}
+
+	l.cells = append(l.cells, c)
+	l.size += size
+
+	return nil
+}
+
+// finish writes pages of all levels and returns the root page. The root is written to the given page if it's not
+// zero.
+func (b *btree) finish(root uint32) (uint32, error) {
+	var child uint32
+
+	for depth, l := range b.levels {
+		page := uint32(0)
+
+		// the first page has less space because of the database header
+		if depth == len(b.levels)-1 && (root != 1 || l.size <= b.capacity-headerSize) {
+			page = root
+		}
+
+		var err error
+
+		child, err = b.writePage(depth, l.cells, child, page)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if root != 0 && child != root {
+		// root without any cells pointing to the top level
+		return b.writePage(len(b.levels), nil, child, root)
+	}
+
+	return child, nil
+}
+
+// writePage writes cells as a page at the given depth, allocating a page if page is zero.
+func (b *btree) writePage(depth int, cells []cell, right uint32, page uint32) (uint32, error) {
+	if page == 0 {
+		page = b.w.allocate()
+	}
+
+	typ := byte(pageLeafTable)
+
+	switch {
+	case depth > 0 && b.index:
+		typ = pageInteriorIndex
+	case depth > 0:
+		typ = pageInteriorTable
+	case b.index:
+		typ = pageLeafIndex
+	}
+
+	buf := make([]byte, PageSize)
+
+	offset := 0
+	if page == 1 {
+		offset = headerSize
+	}
+
+	header := 8
+	if depth > 0 {
+		header = 12
+		binary.BigEndian.PutUint32(buf[offset+8:], right)
+	}
+
+	buf[offset] = typ
+	binary.BigEndian.PutUint16(buf[offset+3:], uint16(len(cells)))
+
+	// cell content grows from the end of the page
+	content := PageSize
+
+	for i, c := range cells {
+		size := len(c.body)
+		if depth > 0 {
+			size += 4
+		}
+
+		content -= size
+
+		if depth > 0 {
+			binary.BigEndian.PutUint32(buf[content:], c.child)
+			copy(buf[content+4:], c.body)
+		} else {
+			copy(buf[content:], c.body)
+		}
+
+		binary.BigEndian.PutUint16(buf[offset+header+2*i:], uint16(content))
+	}
+
+	// zero is interpreted as 65536
+	binary.BigEndian.PutUint16(buf[offset+5:], uint16(content))
+
+	return page, b.w.writePage(page, buf)
+}
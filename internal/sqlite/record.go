@@ -0,0 +1,182 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// putVarint appends v encoded as a SQLite varint, big endian groups of 7 bits with the ninth byte using all 8 bits.
+func putVarint(buf []byte, v uint64) []byte {
+	if v > 0x00ffffffffffffff {
+		var tmp [9]byte
+
+		tmp[8] = byte(v)
+		v >>= 8
+
+		for i := 7; i >= 0; i-- {
+			tmp[i] = byte(v&0x7f) | 0x80
+			v >>= 7
+		}
+
+		return append(buf, tmp[:]...)
+	}
+
+	var tmp [8]byte
+
+	n := 0
+	for {
+		tmp[n] = byte(v&0x7f) | 0x80
+		v >>= 7
+		n++
+
+		if v == 0 {
+			break
+		}
+	}
+
+	// the least significant group ends the varint
+	tmp[0] &= 0x7f
+
+	for i := n - 1; i >= 0; i-- {
+		buf = append(buf, tmp[i])
+	}
+
+	return buf
+}
+
+// varintLen returns the length of v encoded as a SQLite varint.
+func varintLen(v uint64) int {
+	return len(putVarint(nil, v))
+}
+
+// encodeRecord encodes values in the record format, values are nil, int64, float64, string or []byte.
+func encodeRecord(values []interface{}) ([]byte, error) {
+	types := make([]byte, 0, len(values))
+	body := make([]byte, 0, 8*len(values))
+
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = putVarint(types, 0)
+		case int64:
+			typ, n := intSerialType(v)
+			types = putVarint(types, typ)
+
+			for i := n - 1; i >= 0; i-- {
+				body = append(body, byte(v>>(uint(i)*8)))
+			}
+		case float64:
+			types = putVarint(types, 7)
+			body = append(body, make([]byte, 8)...)
+			binary.BigEndian.PutUint64(body[len(body)-8:], math.Float64bits(v))
+		case string:
+			types = putVarint(types, uint64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			types = putVarint(types, uint64(12+2*len(v)))
+			body = append(body, v...)
+		default:
+			return nil, errors.Errorf("unsupported value type %T", v)
+		}
+	}
+
+	// header size includes its own varint
+	size := len(types) + 1
+	for varintLen(uint64(size))+len(types) != size {
+		size = varintLen(uint64(size)) + len(types)
+	}
+
+	record := putVarint(make([]byte, 0, size+len(body)), uint64(size))
+	record = append(record, types...)
+
+	return append(record, body...), nil
+}
+
+// intSerialType returns the serial type and the length of the smallest encoding of the integer.
+func intSerialType(v int64) (uint64, int) {
+	switch {
+	case v == 0:
+		return 8, 0
+	case v == 1:
+		return 9, 0
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		return 1, 1
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		return 2, 2
+	case v >= -1<<23 && v < 1<<23:
+		return 3, 3
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		return 4, 4
+	case v >= -1<<47 && v < 1<<47:
+		return 5, 6
+	default:
+		return 6, 8
+	}
+}
+
+// compareValues compares values the way SQLite does with BINARY collation: nulls first, then numbers, text and blobs.
+func compareValues(a, b interface{}) int {
+	ca, cb := valueClass(a), valueClass(b)
+	if ca != cb {
+		return ca - cb
+	}
+
+	switch a := a.(type) {
+	case int64:
+		if b, ok := b.(int64); ok {
+			return compareInt(a, b)
+		}
+
+		return compareFloat(float64(a), b.(float64))
+	case float64:
+		if b, ok := b.(int64); ok {
+			return compareFloat(a, float64(b))
+		}
+
+		return compareFloat(a, b.(float64))
+	case string:
+		return bytes.Compare([]byte(a), []byte(b.(string)))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	default:
+		return 0
+	}
+}
+
+func valueClass(v interface{}) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	default:
+		return 3
+	}
+}
+
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
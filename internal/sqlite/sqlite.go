@@ -0,0 +1,441 @@
+// Package sqlite writes SQLite database files without a SQLite library. Tables are written one at a time, rows in
+// insertion order, which is sufficient to export data but doesn't support updates.
+package sqlite
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PageSize is the page size of written databases.
+const PageSize = 4096
+
+// file format constants.
+const (
+	headerSize = 100
+
+	// page header size of interior pages, used as the space reserved for headers of all pages
+	pageHeaderSize = 12
+
+	// schema format 4 supports serial types 8 and 9 for integers 0 and 1
+	schemaFormat = 4
+	encodingUTF8 = 1
+	// SQLITE_VERSION_NUMBER of the release the file format is compatible with
+	versionNumber = 3008000
+)
+
+// Column describes a column with its declared type, which determines the column affinity.
+type Column struct {
+	Name string
+	Type string
+}
+
+// ForeignKey describes a foreign key constraint.
+type ForeignKey struct {
+	Columns    []string
+	Table      string
+	RefColumns []string
+}
+
+// Table describes a table to create. A single column primary key of type INTEGER becomes an alias of the rowid, other
+// primary keys and unique constraints are backed by automatic indexes.
+type Table struct {
+	Name        string
+	Columns     []Column
+	PrimaryKey  []string
+	Unique      [][]string
+	ForeignKeys []ForeignKey
+}
+
+// Writer writes a database file.
+type Writer struct {
+	f      io.WriterAt
+	pages  uint32
+	schema [][]interface{}
+	table  *TableWriter
+}
+
+// TableWriter inserts rows into a table.
+type TableWriter struct {
+	w       *Writer
+	name    string
+	tree    *btree
+	rowid   int64
+	rows    int64
+	alias   int
+	indexes []*index
+}
+
+// index buffers the entries of an automatic index, they are sorted and written when the table is closed.
+type index struct {
+	name    string
+	columns []int
+	entries [][]interface{}
+}
+
+// NewWriter creates a Writer writing the database to f.
+func NewWriter(f io.WriterAt) *Writer {
+	// the first page is written last, it contains the header and the schema
+	return &Writer{f: f, pages: 1}
+}
+
+// CreateTable creates the table and returns a writer to insert its rows. The previous table must be closed.
+func (w *Writer) CreateTable(t Table) (*TableWriter, error) {
+	if w.table != nil {
+		return nil, errors.Errorf("table %s is not closed", w.table.name)
+	}
+
+	// names of tables and their automatic indexes are case insensitive
+	for _, row := range w.schema {
+		if row[0] == "table" && strings.EqualFold(row[1].(string), t.Name) {
+			return nil, errors.Errorf("table %s already exists", t.Name)
+		}
+	}
+
+	positions := make(map[string]int, len(t.Columns))
+	for i, c := range t.Columns {
+		positions[c.Name] = i
+	}
+
+	tw := &TableWriter{w: w, name: t.Name, tree: newBTree(w, false, PageSize-pageHeaderSize), alias: -1}
+
+	// automatic indexes are named in the order of constraints in the table definition
+	keys := make([][]string, 0, len(t.Unique)+1)
+
+	if len(t.PrimaryKey) == 1 && isIntegerColumn(t, positions, t.PrimaryKey[0]) {
+		tw.alias = positions[t.PrimaryKey[0]]
+	} else if len(t.PrimaryKey) > 0 {
+		keys = append(keys, t.PrimaryKey)
+	}
+
+	unique := make([][]string, 0, len(t.Unique))
+
+	for _, u := range t.Unique {
+		if sameColumns(u, t.PrimaryKey) || containsColumns(unique, u) {
+			continue
+		}
+
+		unique = append(unique, u)
+		keys = append(keys, u)
+	}
+
+	for _, k := range keys {
+		idx := &index{name: fmt.Sprintf("sqlite_autoindex_%s_%d", t.Name, len(tw.indexes)+1)}
+
+		for _, c := range k {
+			p, ok := positions[c]
+			if !ok {
+				return nil, errors.Errorf("unknown column %s of table %s", c, t.Name)
+			}
+
+			idx.columns = append(idx.columns, p)
+		}
+
+		tw.indexes = append(tw.indexes, idx)
+	}
+
+	t.Unique = unique
+	w.table = tw
+	w.schema = append(w.schema, []interface{}{"table", t.Name, t.Name, int64(0), createTable(t)})
+
+	return tw, nil
+}
+
+// isIntegerColumn reports whether the column of the table exists and has the INTEGER type.
+func isIntegerColumn(t Table, positions map[string]int, column string) bool {
+	p, ok := positions[column]
+	return ok && strings.EqualFold(t.Columns[p].Type, "INTEGER")
+}
+
+// Insert inserts the row, values must be in column order and one of nil, int64, float64, string or []byte. Rows of
+// tables having an integer primary key must be inserted in primary key order.
+func (t *TableWriter) Insert(values []interface{}) error {
+	rowid := t.rowid + 1
+
+	if t.alias >= 0 {
+		id, ok := values[t.alias].(int64)
+		if !ok {
+			return errors.Errorf("integer primary key of table %s must be an integer", t.name)
+		}
+
+		if t.rows > 0 && id <= t.rowid {
+			return errors.Errorf("rows of table %s are not ordered by the primary key", t.name)
+		}
+
+		rowid = id
+
+		// the rowid alias is stored as null
+		values = append([]interface{}(nil), values...)
+		values[t.alias] = nil
+	}
+
+	payload, err := encodeRecord(values)
+	if err != nil {
+		return err
+	}
+
+	key := putVarint(nil, uint64(rowid))
+
+	body, err := t.w.payload(key, payload, PageSize-35)
+	if err != nil {
+		return err
+	}
+
+	if err := t.tree.add(cell{body: body, divider: key}); err != nil {
+		return err
+	}
+
+	t.rowid = rowid
+	t.rows++
+
+	for _, idx := range t.indexes {
+		entry := make([]interface{}, 0, len(idx.columns)+1)
+
+		for _, c := range idx.columns {
+			if c == t.alias {
+				entry = append(entry, rowid)
+			} else {
+				entry = append(entry, values[c])
+			}
+		}
+
+		idx.entries = append(idx.entries, append(entry, rowid))
+	}
+
+	return nil
+}
+
+// Close writes remaining rows and the indexes of the table.
+func (t *TableWriter) Close() error {
+	root, err := t.tree.finish(0)
+	if err != nil {
+		return err
+	}
+
+	t.w.schema[len(t.w.schema)-1][3] = int64(root)
+
+	for _, idx := range t.indexes {
+		root, err := t.writeIndex(idx)
+		if err != nil {
+			return errors.Wrapf(err, "failed to write index %s", idx.name)
+		}
+
+		t.w.schema = append(t.w.schema, []interface{}{"index", idx.name, t.name, int64(root), nil})
+	}
+
+	t.w.table = nil
+
+	return nil
+}
+
+// writeIndex sorts and writes the index entries.
+func (t *TableWriter) writeIndex(idx *index) (uint32, error) {
+	sort.Slice(idx.entries, func(i, j int) bool {
+		a, b := idx.entries[i], idx.entries[j]
+
+		for k := range a {
+			if c := compareValues(a[k], b[k]); c != 0 {
+				return c < 0
+			}
+		}
+
+		return false
+	})
+
+	tree := newBTree(t.w, true, PageSize-pageHeaderSize)
+
+	for _, e := range idx.entries {
+		payload, err := encodeRecord(e)
+		if err != nil {
+			return 0, err
+		}
+
+		body, err := t.w.payload(nil, payload, (PageSize-12)*64/255-23)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := tree.add(cell{body: body, divider: body}); err != nil {
+			return 0, err
+		}
+	}
+
+	idx.entries = nil
+
+	return tree.finish(0)
+}
+
+// Close writes the schema and the database header. It doesn't close the underlying file.
+func (w *Writer) Close() error {
+	if w.table != nil {
+		return errors.Errorf("table %s is not closed", w.table.name)
+	}
+
+	schema := newBTree(w, false, PageSize-pageHeaderSize)
+
+	for i, row := range w.schema {
+		payload, err := encodeRecord(row)
+		if err != nil {
+			return err
+		}
+
+		key := putVarint(nil, uint64(i+1))
+
+		body, err := w.payload(key, payload, PageSize-35)
+		if err != nil {
+			return err
+		}
+
+		if err := schema.add(cell{body: body, divider: key}); err != nil {
+			return err
+		}
+	}
+
+	if _, err := schema.finish(1); err != nil {
+		return err
+	}
+
+	header := make([]byte, headerSize)
+	copy(header, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(header[16:], PageSize)
+	header[18], header[19] = 1, 1 // legacy journal mode
+	header[21], header[22], header[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(header[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(header[28:], w.pages)
+	binary.BigEndian.PutUint32(header[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(header[44:], schemaFormat)
+	binary.BigEndian.PutUint32(header[56:], encodingUTF8)
+	binary.BigEndian.PutUint32(header[92:], 1) // version valid for the change counter
+	binary.BigEndian.PutUint32(header[96:], versionNumber)
+
+	_, err := w.f.WriteAt(header, 0)
+
+	return err
+}
+
+// payload returns the cell body of the payload prefixed by its size and the key, spilling to overflow pages the part
+// exceeding maxLocal.
+func (w *Writer) payload(key, payload []byte, maxLocal int) ([]byte, error) {
+	body := putVarint(nil, uint64(len(payload)))
+	body = append(body, key...)
+
+	if len(payload) <= maxLocal {
+		return append(body, payload...), nil
+	}
+
+	usable := PageSize - 4
+	minLocal := (PageSize-12)*32/255 - 23
+
+	local := minLocal + (len(payload)-minLocal)%usable
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	body = append(body, payload[:local]...)
+	rest := payload[local:]
+
+	// overflow pages are allocated consecutively, each starts with the number of the next one
+	count := (len(rest) + usable - 1) / usable
+	first := w.pages + 1
+
+	body = append(body, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(body[len(body)-4:], first)
+
+	for i := 0; i < count; i++ {
+		page := w.allocate()
+		buf := make([]byte, PageSize)
+
+		if i < count-1 {
+			binary.BigEndian.PutUint32(buf, page+1)
+		}
+
+		rest = rest[copy(buf[4:], rest):]
+
+		if err := w.writePage(page, buf); err != nil {
+			return nil, err
+		}
+	}
+
+	return body, nil
+}
+
+func (w *Writer) allocate() uint32 {
+	w.pages++
+
+	return w.pages
+}
+
+func (w *Writer) writePage(page uint32, buf []byte) error {
+	if _, err := w.f.WriteAt(buf, int64(page-1)*PageSize); err != nil {
+		return errors.Wrapf(err, "failed to write page %d", page)
+	}
+
+	return nil
+}
+
+// createTable returns the CREATE TABLE statement of the table.
+func createTable(t Table) string {
+	defs := make([]string, 0, len(t.Columns)+len(t.Unique)+len(t.ForeignKeys)+1)
+
+	for _, c := range t.Columns {
+		defs = append(defs, strings.TrimSpace(quote(c.Name)+" "+c.Type))
+	}
+
+	if len(t.PrimaryKey) > 0 {
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteList(t.PrimaryKey)))
+	}
+
+	for _, u := range t.Unique {
+		defs = append(defs, fmt.Sprintf("UNIQUE (%s)", quoteList(u)))
+	}
+
+	for _, fk := range t.ForeignKeys {
+		defs = append(defs, fmt.Sprintf(
+			"FOREIGN KEY (%s) REFERENCES %s (%s)", quoteList(fk.Columns), quote(fk.Table), quoteList(fk.RefColumns),
+		))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", quote(t.Name), strings.Join(defs, ",\n  "))
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteList(names []string) string {
+	quoted := make([]string, 0, len(names))
+	for _, n := range names {
+		quoted = append(quoted, quote(n))
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func containsColumns(sets [][]string, columns []string) bool {
+	for _, s := range sets {
+		if sameColumns(s, columns) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,491 @@
+package sqlite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// buffer is an in-memory io.WriterAt.
+type buffer struct {
+	data []byte
+}
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(b.data) {
+		b.data = append(b.data, make([]byte, end-len(b.data))...)
+	}
+
+	return copy(b.data[off:], p), nil
+}
+
+// dbReader reads b-trees and records of a database file.
+type dbReader struct {
+	t    *testing.T
+	data []byte
+}
+
+func (r *dbReader) page(n uint32) []byte {
+	if n == 0 || int(n)*PageSize > len(r.data) {
+		r.t.Fatalf("page %d out of %d pages", n, len(r.data)/PageSize)
+	}
+
+	return r.data[int(n-1)*PageSize : int(n)*PageSize]
+}
+
+func readVarint(b []byte) (uint64, int) {
+	var v uint64
+
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+
+	return v<<8 | uint64(b[8]), 9
+}
+
+// payload reads the payload of the given size from the cell, following its overflow pages.
+func (r *dbReader) payload(cell []byte, size, maxLocal int) []byte {
+	if size <= maxLocal {
+		return cell[:size]
+	}
+
+	minLocal := (PageSize-12)*32/255 - 23
+
+	local := minLocal + (size-minLocal)%(PageSize-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	out := append([]byte(nil), cell[:local]...)
+
+	for next := binary.BigEndian.Uint32(cell[local:]); next != 0 && len(out) < size; {
+		p := r.page(next)
+
+		n := size - len(out)
+		if n > PageSize-4 {
+			n = PageSize - 4
+		}
+
+		out = append(out, p[4:4+n]...)
+		next = binary.BigEndian.Uint32(p)
+	}
+
+	if len(out) != size {
+		r.t.Fatalf("payload has %d bytes, expected %d", len(out), size)
+	}
+
+	return out
+}
+
+// cells returns the type, the cells and the right most child of the b-tree page.
+func (r *dbReader) cells(n uint32) (byte, [][]byte, uint32) {
+	p := r.page(n)
+
+	offset := 0
+	if n == 1 {
+		offset = headerSize
+	}
+
+	typ, header, right := p[offset], 8, uint32(0)
+	if typ == pageInteriorTable || typ == pageInteriorIndex {
+		header, right = 12, binary.BigEndian.Uint32(p[offset+8:])
+	}
+
+	cells := make([][]byte, binary.BigEndian.Uint16(p[offset+3:]))
+	for i := range cells {
+		cells[i] = p[binary.BigEndian.Uint16(p[offset+header+2*i:]):]
+	}
+
+	return typ, cells, right
+}
+
+// table calls fn with the rows of the table b-tree in rowid order.
+func (r *dbReader) table(root uint32, fn func(rowid int64, row []interface{})) {
+	typ, cells, right := r.cells(root)
+
+	for _, c := range cells {
+		switch typ {
+		case pageInteriorTable:
+			r.table(binary.BigEndian.Uint32(c), fn)
+		case pageLeafTable:
+			size, n := readVarint(c)
+			rowid, m := readVarint(c[n:])
+
+			fn(int64(rowid), r.record(r.payload(c[n+m:], int(size), PageSize-35)))
+		default:
+			r.t.Fatalf("unexpected page type %d of table page %d", typ, root)
+		}
+	}
+
+	if typ == pageInteriorTable {
+		r.table(right, fn)
+	}
+}
+
+// index calls fn with the entries of the index b-tree in order.
+func (r *dbReader) index(root uint32, fn func(entry []interface{})) {
+	typ, cells, right := r.cells(root)
+	maxLocal := (PageSize-12)*64/255 - 23
+
+	for _, c := range cells {
+		switch typ {
+		case pageInteriorIndex:
+			r.index(binary.BigEndian.Uint32(c), fn)
+
+			size, n := readVarint(c[4:])
+			fn(r.record(r.payload(c[4+n:], int(size), maxLocal)))
+		case pageLeafIndex:
+			size, n := readVarint(c)
+			fn(r.record(r.payload(c[n:], int(size), maxLocal)))
+		default:
+			r.t.Fatalf("unexpected page type %d of index page %d", typ, root)
+		}
+	}
+
+	if typ == pageInteriorIndex {
+		r.index(right, fn)
+	}
+}
+
+// record decodes the record into nil, int64, float64, string or []byte values.
+func (r *dbReader) record(payload []byte) []interface{} {
+	size, pos := readVarint(payload)
+	body := payload[size:]
+
+	values := make([]interface{}, 0)
+
+	for pos < int(size) {
+		typ, n := readVarint(payload[pos:])
+		pos += n
+
+		switch {
+		case typ == 0:
+			values = append(values, nil)
+		case typ >= 1 && typ <= 6:
+			length := []int{1, 2, 3, 4, 6, 8}[typ-1]
+
+			// sign extension of the big endian integer
+			v := int64(int8(body[0]))
+			for _, b := range body[1:length] {
+				v = v<<8 | int64(b)
+			}
+
+			values, body = append(values, v), body[length:]
+		case typ == 7:
+			values, body = append(values, math.Float64frombits(binary.BigEndian.Uint64(body))), body[8:]
+		case typ == 8 || typ == 9:
+			values = append(values, int64(typ-8))
+		case typ >= 12 && typ%2 == 0:
+			length := int(typ-12) / 2
+			values, body = append(values, append([]byte(nil), body[:length]...)), body[length:]
+		case typ >= 13:
+			length := int(typ-13) / 2
+			values, body = append(values, string(body[:length])), body[length:]
+		default:
+			r.t.Fatalf("unexpected serial type %d", typ)
+		}
+	}
+
+	if len(body) != 0 {
+		r.t.Fatalf("record has %d trailing bytes", len(body))
+	}
+
+	return values
+}
+
+// schema returns the rows of sqlite_master.
+func (r *dbReader) schema() [][]interface{} {
+	rows := make([][]interface{}, 0)
+
+	r.table(1, func(_ int64, row []interface{}) {
+		rows = append(rows, row)
+	})
+
+	return rows
+}
+
+func userRows(n int) [][]interface{} {
+	rows := make([][]interface{}, 0, n)
+
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("user-%05d", n-i)
+
+		// long values spill to overflow pages, in the table and in the index
+		if i%500 == 7 {
+			name += strings.Repeat("x", 3*PageSize+i)
+		}
+
+		row := []interface{}{int64(3*i + 1), name, float64(i) / 3, []byte{byte(i), 0, 0xff}, int64(1) << uint(i%64)}
+
+		if i%10 == 3 {
+			row[2], row[3] = nil, nil
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func writeTestDatabase(t *testing.T, users [][]interface{}) []byte {
+	buf := &buffer{}
+	w := NewWriter(buf)
+
+	tw, err := w.CreateTable(Table{
+		Name: "users",
+		Columns: []Column{
+			{Name: "id", Type: "INTEGER"},
+			{Name: "name", Type: "TEXT"},
+			{Name: "score", Type: "REAL"},
+			{Name: "avatar", Type: "BLOB"},
+			{Name: "flags", Type: "INTEGER"},
+		},
+		PrimaryKey: []string{"id"},
+		Unique:     [][]string{{"name"}, {"id"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range users {
+		if err := tw.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tw, err = w.CreateTable(Table{
+		Name:        "user tags",
+		Columns:     []Column{{Name: "user_id", Type: "INTEGER"}, {Name: "tag", Type: "TEXT"}},
+		PrimaryKey:  []string{"tag", "user_id"},
+		ForeignKeys: []ForeignKey{{Columns: []string{"user_id"}, Table: "users", RefColumns: []string{"id"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range [][]interface{}{{int64(4), "b"}, {int64(1), "b"}, {int64(1), "a"}} {
+		if err := tw.Insert(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.data
+}
+
+func TestRoundTrip(t *testing.T) {
+	users := userRows(3000)
+	data := writeTestDatabase(t, users)
+
+	if !bytes.HasPrefix(data, []byte("SQLite format 3\x00")) || len(data)%PageSize != 0 ||
+		binary.BigEndian.Uint32(data[28:]) != uint32(len(data)/PageSize) {
+		t.Fatal("invalid database header or size")
+	}
+
+	r := &dbReader{t: t, data: data}
+
+	schema := r.schema()
+
+	types := make([]string, 0, len(schema))
+	for _, row := range schema {
+		types = append(types, fmt.Sprintf("%s %s %s", row[0], row[1], row[2]))
+	}
+
+	// the integer primary key is the rowid, the unique constraint repeating it needs no index
+	want := []string{
+		"table users users",
+		"index sqlite_autoindex_users_1 users",
+		"table user tags user tags",
+		"index sqlite_autoindex_user tags_1 user tags",
+	}
+
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("unexpected schema %q", types)
+	}
+
+	if sql := schema[2][4]; sql != "CREATE TABLE \"user tags\" (\n  \"user_id\" INTEGER,\n  \"tag\" TEXT,\n"+
+		"  PRIMARY KEY (\"tag\", \"user_id\"),\n  FOREIGN KEY (\"user_id\") REFERENCES \"users\" (\"id\")\n)" {
+		t.Fatalf("unexpected table definition %q", sql)
+	}
+
+	got := make([][]interface{}, 0, len(users))
+
+	r.table(uint32(schema[0][3].(int64)), func(rowid int64, row []interface{}) {
+		// the rowid alias is stored as null
+		if row[0] != nil {
+			t.Fatalf("rowid alias stored as %v", row[0])
+		}
+
+		row[0] = rowid
+		got = append(got, row)
+	})
+
+	if !reflect.DeepEqual(got, users) {
+		t.Fatalf("read %d rows differing from the %d inserted rows", len(got), len(users))
+	}
+
+	// unique index on name, ordered by name with the rowid
+	wantIndex := make([][]interface{}, 0, len(users))
+	for _, row := range users {
+		wantIndex = append(wantIndex, []interface{}{row[1], row[0]})
+	}
+
+	sort.Slice(wantIndex, func(i, j int) bool { return wantIndex[i][0].(string) < wantIndex[j][0].(string) })
+
+	gotIndex := make([][]interface{}, 0, len(users))
+	r.index(uint32(schema[1][3].(int64)), func(entry []interface{}) { gotIndex = append(gotIndex, entry) })
+
+	if !reflect.DeepEqual(gotIndex, wantIndex) {
+		t.Fatalf("index has %d entries differing from the %d expected entries", len(gotIndex), len(wantIndex))
+	}
+
+	gotIndex = gotIndex[:0]
+	r.index(uint32(schema[3][3].(int64)), func(entry []interface{}) { gotIndex = append(gotIndex, entry) })
+
+	wantIndex = [][]interface{}{{"a", int64(1), int64(3)}, {"b", int64(1), int64(2)}, {"b", int64(4), int64(1)}}
+
+	if !reflect.DeepEqual(gotIndex, wantIndex) {
+		t.Fatalf("unexpected primary key index %v", gotIndex)
+	}
+
+	out, ok := runSQLite(t, data, "PRAGMA integrity_check; SELECT count(*), sum(id) FROM users; "+
+		`SELECT group_concat(tag || user_id) FROM "user tags";`)
+
+	if want := fmt.Sprintf("ok\n%d|%d\nb4,b1,a1\n", len(users), len(users)*(3*len(users)-1)/2); ok && out != want {
+		t.Fatalf("unexpected sqlite3 output %q, want %q", out, want)
+	}
+}
+
+// runSQLite runs the statements on the database with the sqlite3 command, ok is false if it isn't installed.
+func runSQLite(t *testing.T, data []byte, statements string) (string, bool) {
+	bin, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Log("sqlite3 not found, skipping the check of the database with sqlite3")
+		return "", false
+	}
+
+	file := filepath.Join(t.TempDir(), "test.sqlite")
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command(bin, "-batch", "-init", os.DevNull, file, statements).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %v\n%s", err, out)
+	}
+
+	return string(out), true
+}
+
+func TestEmptyDatabase(t *testing.T) {
+	buf := &buffer{}
+
+	if err := NewWriter(buf).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &dbReader{t: t, data: buf.data}
+	if schema := r.schema(); len(schema) != 0 {
+		t.Fatalf("unexpected schema %v", schema)
+	}
+
+	if out, ok := runSQLite(t, buf.data, "PRAGMA integrity_check;"); ok && out != "ok\n" {
+		t.Fatalf("unexpected sqlite3 output %q", out)
+	}
+}
+
+func TestInsertErrors(t *testing.T) {
+	w := NewWriter(&buffer{})
+
+	tw, err := w.CreateTable(Table{
+		Name:       "t",
+		Columns:    []Column{{Name: "id", Type: "INTEGER"}},
+		PrimaryKey: []string{"id"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.CreateTable(Table{Name: "u"}); err == nil {
+		t.Error("table created before the previous one is closed")
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.CreateTable(Table{Name: "T"}); err == nil {
+		t.Error("table created twice")
+	}
+
+	if tw, err = w.CreateTable(Table{
+		Name:       "t2",
+		Columns:    []Column{{Name: "id", Type: "INTEGER"}},
+		PrimaryKey: []string{"id"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Insert([]interface{}{"1"}); err == nil {
+		t.Error("text inserted as integer primary key")
+	}
+
+	if err := tw.Insert([]interface{}{int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Insert([]interface{}{int64(2)}); err == nil {
+		t.Error("rows inserted out of primary key order")
+	}
+
+	if err := w.Close(); err == nil {
+		t.Error("database closed with an open table")
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encodeRecord([]interface{}{int32(1)}); err == nil {
+		t.Error("unsupported value encoded")
+	}
+
+	if _, err := w.CreateTable(Table{Name: "v", PrimaryKey: []string{"missing"}}); err == nil {
+		t.Error("primary key of unknown column accepted")
+	}
+}
+
+func TestVarint(t *testing.T) {
+	values := []uint64{0, 1, 0x7f, 0x80, 0x3fff, 0x4000, 1<<56 - 1, 1 << 56, math.MaxInt64, math.MaxUint64}
+
+	for _, v := range values {
+		buf := putVarint(nil, v)
+
+		if got, n := readVarint(append(buf, 0xff)); got != v || n != len(buf) || n != varintLen(v) {
+			t.Errorf("varint %d decoded as %d of %d bytes", v, got, n)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package watchdog
+
+import "time"
+
+// default values.
+const (
+	DefaultTimeout     = 0
+	DefaultIdleTimeout = 3 * time.Minute
+)
+
+// Config contains timeout options. Zero values disable corresponding timeout.
+type Config struct {
+	// Timeout is the total time allowed for the operation.
+	Timeout time.Duration
+
+	// IdleTimeout is the time allowed without any progress.
+	IdleTimeout time.Duration
+}
@@ -0,0 +1,5 @@
+package watchdog
+
+import "errors"
+
+var ErrStalled = errors.New("operation stalled, no progress within idle timeout")
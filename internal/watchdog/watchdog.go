@@ -0,0 +1,125 @@
+package watchdog
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minimum interval between two idle checks.
+	minCheckInterval = 10 * time.Millisecond
+)
+
+// Watchdog cancels its context when the total timeout is reached or no progress is reported within the idle timeout.
+type Watchdog struct {
+	idle    time.Duration
+	last    int64 // unix nano time of the last progress, accessed atomically
+	stalled int32 // set to 1 when context is cancelled because of idle timeout, accessed atomically
+	cancel  context.CancelFunc
+}
+
+// New returns a context derived from parent and the Watchdog watching it. Zero timeouts are disabled. Calling
+// cancel releases resources associated with the context.
+func New(parent context.Context, cfg Config) (context.Context, *Watchdog, context.CancelFunc) {
+	ctx, cancelTotal := parent, context.CancelFunc(func() {})
+
+	if cfg.Timeout > 0 {
+		ctx, cancelTotal = context.WithTimeout(parent, cfg.Timeout)
+	}
+
+	ctx, cancelIdle := context.WithCancel(ctx)
+
+	w := &Watchdog{idle: cfg.IdleTimeout, last: time.Now().UnixNano(), cancel: cancelIdle}
+
+	if w.idle > 0 {
+		go w.watch(ctx)
+	}
+
+	return ctx, w, func() {
+		cancelIdle()
+		cancelTotal()
+	}
+}
+
+// Touch reports progress and resets the idle timer.
+func (w *Watchdog) Touch() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+}
+
+// Err translates the error caused by the watchdog context to a descriptive one.
+func (w *Watchdog) Err(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if atomic.LoadInt32(&w.stalled) == 1 {
+		return ErrStalled
+	}
+
+	return err
+}
+
+// Writer returns a writer reporting progress for every successful write.
+func (w *Watchdog) Writer(wr io.Writer) io.Writer {
+	return &writer{w: w, wr: wr}
+}
+
+// Reader returns a reader reporting progress for every successful read.
+func (w *Watchdog) Reader(rd io.Reader) io.Reader {
+	return &reader{w: w, rd: rd}
+}
+
+// watch cancels the context when no progress is reported within the idle timeout.
+func (w *Watchdog) watch(ctx context.Context) {
+	interval := w.idle / 4
+	if interval < minCheckInterval {
+		interval = minCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, atomic.LoadInt64(&w.last))) > w.idle {
+				atomic.StoreInt32(&w.stalled, 1)
+				w.cancel()
+
+				return
+			}
+		}
+	}
+}
+
+type writer struct {
+	w  *Watchdog
+	wr io.Writer
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	n, err := w.wr.Write(p)
+	if n > 0 {
+		w.w.Touch()
+	}
+
+	return n, err
+}
+
+type reader struct {
+	w  *Watchdog
+	rd io.Reader
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.rd.Read(p)
+	if n > 0 {
+		r.w.Touch()
+	}
+
+	return n, err
+}
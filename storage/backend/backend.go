@@ -6,38 +6,73 @@ import (
 
 	"github.com/aweris/postgres-data-dump/internal/log"
 	"github.com/aweris/postgres-data-dump/storage/backend/fs"
+	"github.com/aweris/postgres-data-dump/storage/backend/oci"
 	"github.com/pkg/errors"
 )
 
 const (
 	// FileSystem type of the corresponding backend represented as string constant.
 	FileSystem = "filesystem"
+	// OCI type of the corresponding backend represented as string constant.
+	OCI = "oci"
 )
 
 // Backend implements operations for storage files.
 type Backend interface {
 	// Put uploads contents of the given reader.
 	Put(ctx context.Context, p string, r io.Reader) error
+
+	// Get returns contents of the object at given location.
+	Get(ctx context.Context, p string) (io.ReadCloser, error)
 }
 
-// FromConfig creates new Backend by initializing  using given configuration.
-func FromConfig(logger log.Logger, cfg Config) (Backend, error) {
-	var (
-		b   Backend
-		err error
-	)
+// Named is a configured backend with the name identifying it in logs and errors.
+type Named struct {
+	Name string
+	Backend
+}
 
-	switch cfg.Type {
+// FromConfig creates the backends of the given type by initializing using given configuration, a filesystem backend
+// for each configured root.
+func FromConfig(logger log.Logger, typ string, cfg Config) ([]Named, error) {
+	switch typ {
 	case FileSystem:
-		logger.Debug("msg", "using filesystem as backend")
-		b, err = fs.New(logger.With("backend", FileSystem), cfg.FileSystem)
+		logger.Debug("msg", "using filesystem as backend", "roots", len(cfg.FileSystem))
+
+		backends := make([]Named, 0, len(cfg.FileSystem))
+
+		for _, c := range cfg.FileSystem {
+			// a single root keeps the plain type name
+			name := FileSystem
+			if len(cfg.FileSystem) > 1 {
+				name = FileSystem + ":" + c.Root
+			}
+
+			for _, b := range backends {
+				if b.Name == name {
+					return nil, errors.Errorf("filesystem root %s given more than once", c.Root)
+				}
+			}
+
+			b, err := fs.New(logger.With("backend", name), c)
+			if err != nil {
+				return nil, errors.Wrapf(err, "can't initialize backend %s", name)
+			}
+
+			backends = append(backends, Named{Name: name, Backend: b})
+		}
+
+		return backends, nil
+	case OCI:
+		logger.Debug("msg", "using oci registry as backend")
+
+		b, err := oci.New(logger.With("backend", OCI), cfg.OCI)
+		if err != nil {
+			return nil, errors.Wrapf(err, "can't initialize backend %s", typ)
+		}
+
+		return []Named{{Name: OCI, Backend: b}}, nil
 	default:
 		return nil, ErrUnknownBackendType
 	}
-
-	if err != nil {
-		return nil, errors.Wrapf(err, "can't initialize backend %s", cfg.Type)
-	}
-
-	return b, nil
 }
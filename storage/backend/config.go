@@ -1,10 +1,15 @@
 package backend
 
-import "github.com/aweris/postgres-data-dump/storage/backend/fs"
+import (
+	"github.com/aweris/postgres-data-dump/storage/backend/fs"
+	"github.com/aweris/postgres-data-dump/storage/backend/oci"
+)
 
 // Config configures behavior of Backend.
 type Config struct {
-	Type string
+	Types []string
 
-	FileSystem fs.Config
+	// FileSystem configures a filesystem destination for each root, e.g. a network volume and a local disk.
+	FileSystem []fs.Config
+	OCI        oci.Config
 }
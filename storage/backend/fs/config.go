@@ -1,10 +1,16 @@
 package fs
 
+import "os"
+
 const (
-	DefaultRoot = "/tmp/pdd"
+	DefaultRoot     = "/tmp/pdd"
+	DefaultFileMode = os.FileMode(0644)
+	DefaultDirMode  = os.FileMode(0755)
 )
 
 // Config is a structure to store filesystem backend configuration.
 type Config struct {
-	Root string
+	Root     string
+	FileMode os.FileMode
+	DirMode  os.FileMode
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -14,14 +15,12 @@ import (
 	"github.com/pkg/errors"
 )
 
-const (
-	defaultFileMode = 0755
-)
-
 // Backend is an file system implementation of the Backend.
 type Backend struct {
-	root   string
-	logger log.Logger
+	root     string
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	logger   log.Logger
 }
 
 // New creates a Backend backend.
@@ -34,49 +33,145 @@ func New(logger log.Logger, c Config) (*Backend, error) {
 		return nil, errors.Wrapf(err, "make sure volume is mounted, <%s> as root", c.Root)
 	}
 
+	if c.FileMode == 0 {
+		c.FileMode = DefaultFileMode
+	}
+
+	if c.DirMode == 0 {
+		c.DirMode = DefaultDirMode
+	}
+
 	logger.Debug("msg", "fs backend", "config", fmt.Sprintf("%#v", c))
 
-	return &Backend{logger: logger, root: c.Root}, nil
+	return &Backend{logger: logger, root: c.Root, fileMode: c.FileMode, dirMode: c.DirMode}, nil
 }
 
-// Put uploads contents of the given reader.
+// Put uploads contents of the given reader. Contents are written to a temporary file in the same directory which is
+// renamed to the final name only after contents are synced to the disk. On failure or cancellation temporary file is
+// removed, so a partially written file never appears under the given name.
 func (b *Backend) Put(ctx context.Context, p string, r io.Reader) error {
 	fp, err := filepath.Abs(filepath.Clean(filepath.Join(b.root, p)))
 	if err != nil {
 		return errors.Wrapf(err, "invalid file path: %s", p)
 	}
 
-	errCh := make(chan error)
+	dir := filepath.Dir(fp)
+	if err := os.MkdirAll(dir, b.dirMode); err != nil {
+		return errors.Wrap(err, "can't create directory")
+	}
 
-	go func() {
-		defer close(errCh)
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(fp)+".tmp-*")
+	if err != nil {
+		return errors.Wrapf(err, "can't create temporary file for %s", fp)
+	}
 
-		dir := filepath.Dir(fp)
-		if err := os.MkdirAll(dir, os.FileMode(defaultFileMode)); err != nil {
-			errCh <- errors.Wrap(err, "can't create directory")
+	if err := b.write(ctx, tmp, r); err != nil {
+		if rerr := os.Remove(tmp.Name()); rerr != nil {
+			b.logger.Error("msg", "can't remove temporary file", "file", tmp.Name(), "error", rerr)
 		}
 
-		w, err := os.Create(fp)
-		if err != nil {
-			errCh <- errors.Wrapf(err, "can't create file %s", fp)
-			return
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), fp); err != nil {
+		if rerr := os.Remove(tmp.Name()); rerr != nil {
+			b.logger.Error("msg", "can't remove temporary file", "file", tmp.Name(), "error", rerr)
 		}
 
-		defer helpers.CloseWithErrLogf(b.logger, w, "response body, close defer")
+		return errors.Wrapf(err, "can't rename temporary file to %s", fp)
+	}
 
-		if _, err := io.Copy(w, r); err != nil {
-			errCh <- errors.Wrapf(err, "can't write contents of reader to a file %s", fp)
-		}
+	if err := syncDir(dir); err != nil {
+		return errors.Wrapf(err, "can't sync directory %s", dir)
+	}
+
+	b.logger.Debug("msg", "file written", "file", fp)
+
+	return nil
+}
+
+// Get returns contents of the file at the given path.
+func (b *Backend) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	fp, err := filepath.Abs(filepath.Clean(filepath.Join(b.root, p)))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid file path: %s", p)
+	}
+
+	f, err := os.Open(fp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't open file %s", fp)
+	}
+
+	return f, nil
+}
+
+// write copies contents of the reader to the file, syncs and closes it. The file is closed on every path, also when
+// writing fails. Copy is interrupted when context is done.
+func (b *Backend) write(ctx context.Context, f *os.File, r io.Reader) error {
+	if err := f.Chmod(b.fileMode); err != nil {
+		helpers.CloseWithErrLogf(b.logger, f, "temporary file, close after failure")
 
-		if err := w.Close(); err != nil {
-			errCh <- errors.Wrapf(err, "can't close object %s", fp)
+		return errors.Wrapf(err, "can't change mode of %s", f.Name())
+	}
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(errCh)
+
+		if _, err := io.Copy(f, r); err != nil {
+			errCh <- errors.Wrapf(err, "can't write contents of reader to a file %s", f.Name())
 		}
 	}()
 
 	select {
 	case err := <-errCh:
-		return err
+		if err != nil {
+			helpers.CloseWithErrLogf(b.logger, f, "temporary file, close after failure")
+
+			return err
+		}
 	case <-ctx.Done():
+		// closing the file unblocks the copy on the writer side, the reader side is unblocked only if it can be
+		// closed. A copy blocked in a read of other readers returns with the next read, writing to the closed file fails.
+		c, closable := r.(interface{ CloseWithError(error) error })
+		if closable {
+			_ = c.CloseWithError(ctx.Err())
+		}
+
+		helpers.CloseWithErrLogf(b.logger, f, "temporary file, close on cancel")
+
+		if closable {
+			<-errCh
+		}
+
 		return ctx.Err()
 	}
+
+	if err := f.Sync(); err != nil {
+		helpers.CloseWithErrLogf(b.logger, f, "temporary file, close after failure")
+
+		return errors.Wrapf(err, "can't sync file %s", f.Name())
+	}
+
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "can't close object %s", f.Name())
+	}
+
+	return nil
+}
+
+// syncDir flushes directory entry changes, e.g. renames, to the disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+
+	return d.Close()
 }
@@ -0,0 +1,142 @@
+package fs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+func newTestBackend(t *testing.T) (*Backend, string) {
+	logger, err := log.NewLogger(log.LevelInfo, log.FormatFmt, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := t.TempDir()
+
+	b, err := New(logger, Config{Root: root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, root
+}
+
+// entries returns the names of the files in the directory.
+func entries(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+
+	return names
+}
+
+func TestPut(t *testing.T) {
+	b, root := newTestBackend(t)
+
+	if err := b.Put(context.Background(), "dumps/dump.sql", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Put(context.Background(), "dumps/dump.sql", strings.NewReader("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(root, "dumps", "dump.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != DefaultFileMode {
+		t.Errorf("unexpected file mode %v", info.Mode())
+	}
+
+	rc, err := b.Get(context.Background(), "dumps/dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	if data, err := ioutil.ReadAll(rc); err != nil || string(data) != "new" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+
+	if names := entries(t, filepath.Join(root, "dumps")); len(names) != 1 {
+		t.Fatalf("unexpected files %v", names)
+	}
+}
+
+func TestPutFailure(t *testing.T) {
+	b, root := newTestBackend(t)
+
+	if err := b.Put(context.Background(), "dump.sql", strings.NewReader("old")); err != nil {
+		t.Fatal(err)
+	}
+
+	r := io.MultiReader(strings.NewReader("partial"), errReader{})
+
+	if err := b.Put(context.Background(), "dump.sql", r); err == nil {
+		t.Fatal("expected put to fail")
+	}
+
+	// existing file is kept and the temporary file is removed
+	data, err := ioutil.ReadFile(filepath.Join(root, "dump.sql"))
+	if err != nil || string(data) != "old" {
+		t.Fatalf("read %q, %v", data, err)
+	}
+
+	if names := entries(t, root); len(names) != 1 {
+		t.Fatalf("unexpected files %v", names)
+	}
+}
+
+func TestPutCancel(t *testing.T) {
+	b, root := newTestBackend(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pr, pw := io.Pipe()
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- b.Put(ctx, "dump.sql", pr)
+	}()
+
+	if _, err := pw.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+
+	// writer is unblocked by closing the pipe
+	if _, err := pw.Write([]byte("more")); err == nil {
+		t.Fatal("expected write to fail")
+	}
+
+	if names := entries(t, root); len(names) != 0 {
+		t.Fatalf("unexpected files %v", names)
+	}
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errors.New("source failed")
+}
@@ -0,0 +1,256 @@
+package oci
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// challengeParam matches key="value" pairs of the WWW-Authenticate header.
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// client is a minimal OCI distribution API client taking care of the registry authentication.
+type client struct {
+	http     *http.Client
+	base     *url.URL
+	username string
+	password string
+
+	mu    sync.Mutex
+	authz string // Authorization header value used for the requests
+}
+
+func newClient(host string, plainHTTP bool, username, password string) *client {
+	scheme := "https"
+	if plainHTTP {
+		scheme = "http"
+	}
+
+	if username == "" && password == "" {
+		username, password = dockerCredentials(host)
+	}
+
+	return &client{
+		http:     &http.Client{},
+		base:     &url.URL{Scheme: scheme, Host: host},
+		username: username,
+		password: password,
+	}
+}
+
+// url resolves given path or location header against the registry base url.
+func (c *client) url(ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid url %s", ref)
+	}
+
+	return c.base.ResolveReference(u).String(), nil
+}
+
+// do sends the request and authenticates on the first unauthorized response, e.g. when the token expired. Requests
+// with a body are retried only if the body can be rewound, so large contents are sent in buffered chunks.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	authz := c.authz
+	c.mu.Unlock()
+
+	if authz != "" {
+		req.Header.Set("Authorization", authz)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		return resp, nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	drain(resp)
+
+	if err := c.authenticate(req.Context(), challenge); err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil && req.GetBody == nil {
+		return nil, errors.New("unauthorized, request body can't be replayed")
+	}
+
+	retry := req.Clone(req.Context())
+
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.Lock()
+	retry.Header.Set("Authorization", c.authz)
+	c.mu.Unlock()
+
+	return c.http.Do(retry)
+}
+
+// authenticate resolves the Authorization header for the given WWW-Authenticate challenge.
+func (c *client) authenticate(ctx context.Context, challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return errors.New("registry requires basic authentication but no credentials given")
+		}
+
+		c.setAuthz("Basic " + base64.StdEncoding.EncodeToString([]byte(c.username+":"+c.password)))
+
+		return nil
+	case "bearer":
+		return c.fetchToken(ctx, challenge)
+	default:
+		return errors.Errorf("unsupported authentication challenge %q", challenge)
+	}
+}
+
+// fetchToken requests a bearer token from the token server given in the challenge.
+func (c *client) fetchToken(ctx context.Context, challenge string) error {
+	params := make(map[string]string)
+	for _, m := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return errors.Errorf("invalid authentication realm in challenge %q", challenge)
+	}
+
+	q := realm.Query()
+
+	if service := params["service"]; service != "" {
+		q.Set("service", service)
+	}
+
+	for _, scope := range strings.Fields(params["scope"]) {
+		q.Add("scope", scope)
+	}
+
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch registry token")
+	}
+
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to fetch registry token, unexpected status %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return errors.Wrap(err, "failed to decode registry token")
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	c.setAuthz("Bearer " + token.Token)
+
+	return nil
+}
+
+func (c *client) setAuthz(authz string) {
+	c.mu.Lock()
+	c.authz = authz
+	c.mu.Unlock()
+}
+
+// dockerCredentials returns the credentials of the registry stored by `docker login`, if any.
+func dockerCredentials(host string) (string, string) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", ""
+		}
+
+		dir = filepath.Join(home, ".docker")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return "", ""
+	}
+
+	var cfg struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return "", ""
+	}
+
+	for _, key := range []string{host, "https://" + host, "http://" + host} {
+		entry, ok := cfg.Auths[key]
+		if !ok {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return "", ""
+		}
+
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", ""
+		}
+
+		return parts[0], parts[1]
+	}
+
+	return "", ""
+}
+
+// responseError creates an error from the unexpected response including registry error message.
+func responseError(resp *http.Response, action string) error {
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	return errors.Errorf("%s: unexpected status %s: %s", action, resp.Status, strings.TrimSpace(fmt.Sprintf("%.512s", body)))
+}
+
+// drain discards remaining body to allow connection reuse, and closes it.
+func drain(resp *http.Response) {
+	_, _ = ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+}
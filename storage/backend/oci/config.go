@@ -0,0 +1,25 @@
+package oci
+
+// default values.
+const (
+	DefaultTagTemplate = `{{ .Time.Format "2006-01-02" }}`
+	DefaultMediaType   = "application/vnd.pdd.dump.v1.sql"
+)
+
+// Config is a structure to store OCI registry backend configuration.
+type Config struct {
+	// Repository is the target repository including registry host, e.g. registry.example.com/team/sample-db.
+	Repository string
+
+	// TagTemplate is a text/template rendering the tag of the artifact. Template has access to .Key, .Name and .Time.
+	TagTemplate string
+
+	// MediaType is the media type of the layer holding the dump.
+	MediaType string
+
+	Username string
+	Password string
+
+	// PlainHTTP uses http instead of https to connect to the registry.
+	PlainHTTP bool
+}
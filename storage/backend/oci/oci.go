@@ -0,0 +1,440 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// OCI media types and annotations.
+const (
+	mediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeConfig   = "application/vnd.pdd.dump.config.v1+json"
+	artifactType      = "application/vnd.pdd.dump.v1"
+
+	annotationTitle   = "org.opencontainers.image.title"
+	annotationCreated = "org.opencontainers.image.created"
+)
+
+// uploadChunkSize is the size of blob chunks. Chunks are buffered, so they can be sent again after the registry token
+// expires during long uploads.
+const uploadChunkSize = 8 << 20
+
+// validTag matches tags allowed by the OCI distribution spec.
+var validTag = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+
+// validDigest matches sha256 digests of manifests.
+var validDigest = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Backend is an OCI registry implementation of the Backend. Each object is pushed as an artifact tagged with the
+// rendered tag template. Keys of pushed objects are mapped to tags, artifacts are pulled by their tag or digest, so
+// only dumps stored as a single whole object can be read back.
+type Backend struct {
+	logger    log.Logger
+	client    *client
+	repo      string
+	tag       *template.Template
+	mediaType string
+	chunkSize int
+}
+
+// descriptor describes the content addressable blob.
+type descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// manifest is the OCI image manifest of the dump artifact.
+type manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        descriptor        `json:"config"`
+	Layers        []descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// metadata is stored as the artifact config and describes the dump.
+type metadata struct {
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+	Digest  string    `json:"digest"`
+	Size    int64     `json:"size"`
+}
+
+// New creates a Backend backend.
+func New(logger log.Logger, c Config) (*Backend, error) {
+	parts := strings.SplitN(c.Repository, "/", 2)
+	if len(parts) != 2 || parts[1] == "" || !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		return nil, errors.Errorf("repository must include registry host, <%s> as repository", c.Repository)
+	}
+
+	if c.TagTemplate == "" {
+		c.TagTemplate = DefaultTagTemplate
+	}
+
+	if c.MediaType == "" {
+		c.MediaType = DefaultMediaType
+	}
+
+	tmpl, err := template.New("tag").Parse(c.TagTemplate)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid tag template %s", c.TagTemplate)
+	}
+
+	logger.Debug("msg", "oci backend", "repository", c.Repository, "tag", c.TagTemplate, "media-type", c.MediaType)
+
+	return &Backend{
+		logger:    logger,
+		client:    newClient(parts[0], c.PlainHTTP, c.Username, c.Password),
+		repo:      parts[1],
+		tag:       tmpl,
+		mediaType: c.MediaType,
+		chunkSize: uploadChunkSize,
+	}, nil
+}
+
+// Put pushes contents of the given reader as an artifact tagged with the rendered tag template.
+func (b *Backend) Put(ctx context.Context, p string, r io.Reader) error {
+	now := time.Now().UTC()
+
+	tag, err := b.renderTag(p, now)
+	if err != nil {
+		return err
+	}
+
+	layer, err := b.pushBlob(ctx, r)
+	if err != nil {
+		return errors.Wrap(err, "can't push dump")
+	}
+
+	layer.MediaType = b.mediaType
+	layer.Annotations = map[string]string{annotationTitle: path.Base(p)}
+
+	meta, err := json.Marshal(metadata{Key: p, Created: now, Digest: layer.Digest, Size: layer.Size})
+	if err != nil {
+		return err
+	}
+
+	config, err := b.pushBlob(ctx, bytes.NewReader(meta))
+	if err != nil {
+		return errors.Wrap(err, "can't push dump metadata")
+	}
+
+	config.MediaType = mediaTypeConfig
+
+	m := manifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeManifest,
+		ArtifactType:  artifactType,
+		Config:        config,
+		Layers:        []descriptor{layer},
+		Annotations:   map[string]string{annotationCreated: now.Format(time.RFC3339)},
+	}
+
+	if err := b.pushManifest(ctx, tag, m); err != nil {
+		return err
+	}
+
+	b.logger.Debug("msg", "artifact pushed", "repository", b.repo, "tag", tag, "digest", layer.Digest, "size", layer.Size)
+
+	return nil
+}
+
+// Get pulls the dump of the artifact with the given tag or digest. Object keys, e.g. files of a dump, can't be pulled
+// since their tags are rendered when they are pushed.
+func (b *Backend) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	if !validTag.MatchString(p) && !validDigest.MatchString(p) {
+		return nil, errors.Errorf("%s is not a tag or digest, oci backend supports only dumps of a single object", p)
+	}
+
+	m, err := b.pullManifest(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, layer := range m.Layers {
+		if layer.MediaType == b.mediaType {
+			return b.pullBlob(ctx, layer)
+		}
+	}
+
+	return nil, errors.Errorf("artifact %s has no layer with media type %s", p, b.mediaType)
+}
+
+// renderTag renders tag template for the given key.
+func (b *Backend) renderTag(p string, t time.Time) (string, error) {
+	name := path.Base(p)
+	name = strings.TrimSuffix(name, path.Ext(name))
+
+	var out bytes.Buffer
+	if err := b.tag.Execute(&out, struct {
+		Key  string
+		Name string
+		Time time.Time
+	}{p, name, t}); err != nil {
+		return "", errors.Wrap(err, "can't render tag template")
+	}
+
+	tag := out.String()
+	if !validTag.MatchString(tag) {
+		return "", errors.Errorf("invalid tag %q rendered for %s", tag, p)
+	}
+
+	return tag, nil
+}
+
+// pushBlob uploads contents of the reader as a blob. Upload is started with a small authenticated request, then the
+// content is sent in chunks and the upload is completed with the digest computed on the fly.
+func (b *Backend) pushBlob(ctx context.Context, r io.Reader) (descriptor, error) {
+	location, err := b.startUpload(ctx)
+	if err != nil {
+		return descriptor{}, err
+	}
+
+	counter := &countingHash{Hash: sha256.New()}
+	buf := make([]byte, b.chunkSize)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return descriptor{}, err
+		}
+
+		// an empty blob is uploaded with an empty chunk
+		if n > 0 || counter.n == 0 {
+			if location, err = b.uploadChunk(ctx, location, counter.n, buf[:n]); err != nil {
+				return descriptor{}, err
+			}
+
+			_, _ = counter.Write(buf[:n])
+		}
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	digest := "sha256:" + hex.EncodeToString(counter.Sum(nil))
+
+	if err := b.completeUpload(ctx, location, digest); err != nil {
+		return descriptor{}, err
+	}
+
+	return descriptor{Digest: digest, Size: counter.n}, nil
+}
+
+// uploadChunk uploads the chunk starting at the given offset of the blob and returns the location of the next chunk.
+func (b *Backend) uploadChunk(ctx context.Context, location string, offset int64, chunk []byte) (string, error) {
+	req, err := b.request(ctx, http.MethodPatch, location, bytes.NewReader(chunk))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	if len(chunk) > 0 {
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(len(chunk))-1))
+	}
+
+	resp, err := b.client.do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "can't upload blob")
+	}
+
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp, "can't upload blob")
+	}
+
+	return resp.Header.Get("Location"), nil
+}
+
+// startUpload starts a blob upload session and returns its location.
+func (b *Backend) startUpload(ctx context.Context) (string, error) {
+	req, err := b.request(ctx, http.MethodPost, fmt.Sprintf("/v2/%s/blobs/uploads/", b.repo), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := b.client.do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "can't start blob upload")
+	}
+
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusAccepted {
+		return "", responseError(resp, "can't start blob upload")
+	}
+
+	return resp.Header.Get("Location"), nil
+}
+
+// completeUpload completes the blob upload with its digest.
+func (b *Backend) completeUpload(ctx context.Context, location, digest string) error {
+	u, err := b.client.url(location)
+	if err != nil {
+		return err
+	}
+
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = "&"
+	}
+
+	req, err := b.request(ctx, http.MethodPut, u+sep+"digest="+digest, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.client.do(req)
+	if err != nil {
+		return errors.Wrap(err, "can't complete blob upload")
+	}
+
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "can't complete blob upload")
+	}
+
+	return nil
+}
+
+// pushManifest uploads the manifest with the given tag.
+func (b *Backend) pushManifest(ctx context.Context, tag string, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	req, err := b.request(ctx, http.MethodPut, fmt.Sprintf("/v2/%s/manifests/%s", b.repo, tag), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", mediaTypeManifest)
+
+	resp, err := b.client.do(req)
+	if err != nil {
+		return errors.Wrap(err, "can't push manifest")
+	}
+
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusCreated {
+		return responseError(resp, "can't push manifest")
+	}
+
+	return nil
+}
+
+// pullManifest fetches the manifest of the given reference.
+func (b *Backend) pullManifest(ctx context.Context, ref string) (*manifest, error) {
+	req, err := b.request(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/manifests/%s", b.repo, ref), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Accept", mediaTypeManifest)
+
+	resp, err := b.client.do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't pull manifest")
+	}
+
+	defer drain(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, fmt.Sprintf("can't pull manifest %s", ref))
+	}
+
+	m := manifest{}
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, errors.Wrapf(err, "can't decode manifest %s", ref)
+	}
+
+	return &m, nil
+}
+
+// pullBlob returns contents of the blob. Digest of the content is verified once the reader is consumed.
+func (b *Backend) pullBlob(ctx context.Context, d descriptor) (io.ReadCloser, error) {
+	req, err := b.request(ctx, http.MethodGet, fmt.Sprintf("/v2/%s/blobs/%s", b.repo, d.Digest), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't pull blob")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer drain(resp)
+		return nil, responseError(resp, fmt.Sprintf("can't pull blob %s", d.Digest))
+	}
+
+	return &verifyingReader{body: resp.Body, hash: sha256.New(), digest: d.Digest}, nil
+}
+
+func (b *Backend) request(ctx context.Context, method, ref string, body io.Reader) (*http.Request, error) {
+	u, err := b.client.url(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.NewRequestWithContext(ctx, method, u, body)
+}
+
+// countingHash is a hash counting written bytes.
+type countingHash struct {
+	hash.Hash
+	n int64
+}
+
+func (c *countingHash) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return c.Hash.Write(p)
+}
+
+// verifyingReader verifies the digest of the content on EOF.
+type verifyingReader struct {
+	body   io.ReadCloser
+	hash   hash.Hash
+	digest string
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if actual := "sha256:" + hex.EncodeToString(v.hash.Sum(nil)); actual != v.digest {
+			return n, errors.Errorf("digest mismatch, expected %s got %s", v.digest, actual)
+		}
+	}
+
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
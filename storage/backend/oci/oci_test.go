@@ -0,0 +1,420 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/internal/log"
+)
+
+const (
+	testRepo     = "team/sample-db"
+	testUser     = "user"
+	testPassword = "secret"
+	testToken    = "token"
+)
+
+// registry is a minimal in-memory OCI registry. Requests are authenticated with the given scheme, "basic" or "bearer".
+type registry struct {
+	server *httptest.Server
+	auth   string
+
+	// tokenUses is the number of requests a bearer token is valid for, zero means no limit.
+	tokenUses int
+	tokens    int
+	uses      int
+
+	mu        sync.Mutex
+	uploads   map[string]*bytes.Buffer
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	requests  []string
+}
+
+func newRegistry(t *testing.T, auth string) *registry {
+	r := &registry{
+		auth:      auth,
+		uploads:   make(map[string]*bytes.Buffer),
+		blobs:     make(map[string][]byte),
+		manifests: make(map[string][]byte),
+	}
+
+	r.server = httptest.NewServer(r)
+	t.Cleanup(r.server.Close)
+
+	return r
+}
+
+func (r *registry) host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+func (r *registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.token(w, req)
+		return
+	}
+
+	if !r.authorized(w, req) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+
+	prefix := "/v2/" + testRepo
+	p := strings.TrimPrefix(req.URL.Path, prefix)
+
+	switch {
+	case req.Method == http.MethodPost && p == "/blobs/uploads/":
+		id := fmt.Sprintf("upload-%d", len(r.uploads))
+		r.uploads[id] = &bytes.Buffer{}
+
+		// the state query ensures upload locations are used as they are, with the digest appended
+		w.Header().Set("Location", prefix+"/blobs/uploads/"+id+"?state=started")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPatch && strings.HasPrefix(p, "/blobs/uploads/"):
+		id := strings.TrimPrefix(p, "/blobs/uploads/")
+
+		buf, ok := r.uploads[id]
+		if state := req.URL.Query().Get("state"); !ok || state != "started" && state != "uploaded" {
+			http.Error(w, "unknown upload", http.StatusNotFound)
+			return
+		}
+
+		// chunks must follow each other
+		if cr := req.Header.Get("Content-Range"); cr != "" && !strings.HasPrefix(cr, fmt.Sprintf("%d-", buf.Len())) {
+			http.Error(w, "unexpected range "+cr, http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+
+		if _, err := buf.ReadFrom(req.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Location", prefix+"/blobs/uploads/"+id+"?state=uploaded")
+		w.WriteHeader(http.StatusAccepted)
+	case req.Method == http.MethodPut && strings.HasPrefix(p, "/blobs/uploads/"):
+		id := strings.TrimPrefix(p, "/blobs/uploads/")
+
+		buf, ok := r.uploads[id]
+		if !ok || req.URL.Query().Get("state") != "uploaded" {
+			http.Error(w, "unknown upload", http.StatusNotFound)
+			return
+		}
+
+		digest := req.URL.Query().Get("digest")
+		if digest != digestOf(buf.Bytes()) {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+
+		r.blobs[digest] = buf.Bytes()
+		delete(r.uploads, id)
+
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodPut && strings.HasPrefix(p, "/manifests/"):
+		if ct := req.Header.Get("Content-Type"); ct != mediaTypeManifest {
+			http.Error(w, "unexpected content type "+ct, http.StatusBadRequest)
+			return
+		}
+
+		data, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r.manifests[strings.TrimPrefix(p, "/manifests/")] = data
+		r.manifests[digestOf(data)] = data
+
+		w.WriteHeader(http.StatusCreated)
+	case req.Method == http.MethodGet && strings.HasPrefix(p, "/manifests/"):
+		data, ok := r.manifests[strings.TrimPrefix(p, "/manifests/")]
+		if !ok {
+			http.Error(w, "manifest unknown", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", mediaTypeManifest)
+		_, _ = w.Write(data)
+	case req.Method == http.MethodGet && strings.HasPrefix(p, "/blobs/"):
+		data, ok := r.blobs[strings.TrimPrefix(p, "/blobs/")]
+		if !ok {
+			http.Error(w, "blob unknown", http.StatusNotFound)
+			return
+		}
+
+		_, _ = w.Write(data)
+	default:
+		http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized checks the credentials of the request, responds with a challenge if they are missing or wrong.
+func (r *registry) authorized(w http.ResponseWriter, req *http.Request) bool {
+	switch r.auth {
+	case "basic":
+		if user, password, ok := req.BasicAuth(); ok && user == testUser && password == testPassword {
+			return true
+		}
+
+		w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+	case "bearer":
+		r.mu.Lock()
+		valid := req.Header.Get("Authorization") == fmt.Sprintf("Bearer %s-%d", testToken, r.tokens) &&
+			(r.tokenUses == 0 || r.uses < r.tokenUses)
+		if valid {
+			r.uses++
+		}
+		r.mu.Unlock()
+
+		if valid {
+			return true
+		}
+
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+			`Bearer realm="%s/token",service="registry",scope="repository:%s:pull,push"`, r.server.URL, testRepo))
+	default:
+		return true
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+
+	return false
+}
+
+// token issues bearer tokens for the valid credentials and scope.
+func (r *registry) token(w http.ResponseWriter, req *http.Request) {
+	user, password, ok := req.BasicAuth()
+	if !ok || user != testUser || password != testPassword {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	q := req.URL.Query()
+	if q.Get("service") != "registry" || q.Get("scope") != "repository:"+testRepo+":pull,push" {
+		http.Error(w, "unexpected token request "+req.URL.RawQuery, http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	r.tokens++
+	r.uses = 0
+	token := fmt.Sprintf("%s-%d", testToken, r.tokens)
+	r.mu.Unlock()
+
+	_ = json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newTestBackend(t *testing.T, r *registry, username, password string) *Backend {
+	logger, err := log.NewLogger(log.LevelInfo, log.FormatFmt, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(logger, Config{
+		Repository:  r.host() + "/" + testRepo,
+		TagTemplate: "{{ .Name }}",
+		Username:    username,
+		Password:    password,
+		PlainHTTP:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestPushPull(t *testing.T) {
+	for _, auth := range []string{"none", "basic", "bearer"} {
+		auth := auth
+
+		t.Run(auth, func(t *testing.T) {
+			r := newRegistry(t, auth)
+			b := newTestBackend(t, r, testUser, testPassword)
+
+			content := []byte(strings.Repeat("INSERT INTO users VALUES (1);\n", 1000))
+
+			if err := b.Put(context.Background(), "dump-20261018-120000.sql", bytes.NewReader(content)); err != nil {
+				t.Fatalf("put: %v", err)
+			}
+
+			want := []string{
+				"POST /v2/" + testRepo + "/blobs/uploads/",
+				"PATCH /v2/" + testRepo + "/blobs/uploads/upload-0",
+				"PUT /v2/" + testRepo + "/blobs/uploads/upload-0",
+				"POST /v2/" + testRepo + "/blobs/uploads/",
+				"PATCH /v2/" + testRepo + "/blobs/uploads/upload-0",
+				"PUT /v2/" + testRepo + "/blobs/uploads/upload-0",
+				"PUT /v2/" + testRepo + "/manifests/dump-20261018-120000",
+			}
+
+			if got := strings.Join(r.requests, "\n"); got != strings.Join(want, "\n") {
+				t.Fatalf("unexpected requests\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+			}
+
+			var m manifest
+			if err := json.Unmarshal(r.manifests["dump-20261018-120000"], &m); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(m.Layers) != 1 || m.Layers[0].MediaType != DefaultMediaType || m.Layers[0].Digest != digestOf(content) ||
+				m.Layers[0].Annotations[annotationTitle] != "dump-20261018-120000.sql" {
+				t.Fatalf("unexpected layers %+v", m.Layers)
+			}
+
+			var meta metadata
+			if err := json.Unmarshal(r.blobs[m.Config.Digest], &meta); err != nil {
+				t.Fatal(err)
+			}
+
+			if meta.Key != "dump-20261018-120000.sql" || meta.Size != int64(len(content)) {
+				t.Fatalf("unexpected metadata %+v", meta)
+			}
+
+			refs := []string{"dump-20261018-120000", digestOf(r.manifests["dump-20261018-120000"])}
+
+			for _, ref := range refs {
+				rc, err := b.Get(context.Background(), ref)
+				if err != nil {
+					t.Fatalf("get %s: %v", ref, err)
+				}
+
+				got, err := ioutil.ReadAll(rc)
+				_ = rc.Close()
+
+				if err != nil {
+					t.Fatalf("read %s: %v", ref, err)
+				}
+
+				if !bytes.Equal(got, content) {
+					t.Fatalf("pulled content of %s differs, %d bytes, want %d bytes", ref, len(got), len(content))
+				}
+			}
+		})
+	}
+}
+
+func TestPushUnauthorized(t *testing.T) {
+	for _, auth := range []string{"basic", "bearer"} {
+		auth := auth
+
+		t.Run(auth, func(t *testing.T) {
+			r := newRegistry(t, auth)
+			b := newTestBackend(t, r, testUser, "wrong")
+
+			if err := b.Put(context.Background(), "dump.sql", strings.NewReader("data")); err == nil {
+				t.Fatal("put succeeded with wrong credentials")
+			}
+
+			if len(r.blobs) != 0 || len(r.manifests) != 0 {
+				t.Fatal("registry stored contents of an unauthorized push")
+			}
+		})
+	}
+}
+
+func TestPullDigestMismatch(t *testing.T) {
+	r := newRegistry(t, "none")
+	b := newTestBackend(t, r, "", "")
+
+	if err := b.Put(context.Background(), "dump.sql", strings.NewReader("data")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	r.blobs[digestOf([]byte("data"))] = []byte("tampered")
+
+	rc, err := b.Get(context.Background(), "dump")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	defer rc.Close()
+
+	if _, err := ioutil.ReadAll(rc); err == nil || !strings.Contains(err.Error(), "digest mismatch") {
+		t.Fatalf("expected digest mismatch, got %v", err)
+	}
+}
+
+func TestPullObjectKey(t *testing.T) {
+	r := newRegistry(t, "none")
+	b := newTestBackend(t, r, "", "")
+
+	if _, err := b.Get(context.Background(), "dump-20261018-120000/manifest.json"); err == nil {
+		t.Fatal("object key pulled as a tag")
+	}
+
+	if len(r.requests) != 0 {
+		t.Fatalf("unexpected requests %v", r.requests)
+	}
+}
+
+func TestPushChunksTokenExpiry(t *testing.T) {
+	r := newRegistry(t, "bearer")
+	r.tokenUses = 2
+
+	b := newTestBackend(t, r, testUser, testPassword)
+	b.chunkSize = 1000
+
+	content := []byte(strings.Repeat("INSERT INTO users VALUES (1);\n", 200))
+
+	if err := b.Put(context.Background(), "dump.sql", bytes.NewReader(content)); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if got := r.blobs[digestOf(content)]; !bytes.Equal(got, content) {
+		t.Fatalf("pushed content differs, %d bytes, want %d bytes", len(got), len(content))
+	}
+
+	// each chunk is a request, expired tokens are fetched again
+	if patches := strings.Count(strings.Join(r.requests, "\n"), "PATCH"); patches != 7 || r.tokens < 4 {
+		t.Fatalf("%d chunks uploaded with %d tokens", patches, r.tokens)
+	}
+}
+
+func TestPushEmpty(t *testing.T) {
+	r := newRegistry(t, "none")
+	b := newTestBackend(t, r, "", "")
+
+	if err := b.Put(context.Background(), "dump.sql", strings.NewReader("")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if _, ok := r.blobs[digestOf(nil)]; !ok {
+		t.Fatal("empty blob not pushed")
+	}
+}
+
+func TestFetchTokenContext(t *testing.T) {
+	r := newRegistry(t, "bearer")
+	b := newTestBackend(t, r, testUser, testPassword)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	challenge := fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, r.server.URL)
+
+	if err := b.client.fetchToken(ctx, challenge); err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,18 @@
+package storage
+
+const (
+	// PolicyAllMustSucceed fails the operation if any of the destinations fails.
+	PolicyAllMustSucceed = "all-must-succeed"
+	// PolicyAtLeastOne fails the operation only if all of the destinations fail.
+	PolicyAtLeastOne = "at-least-one"
+)
+
+// default values.
+const (
+	DefaultPolicy = PolicyAllMustSucceed
+)
+
+// Config configures behavior of Storage.
+type Config struct {
+	Policy string
+}
@@ -0,0 +1,9 @@
+package storage
+
+import "errors"
+
+var (
+	ErrUnknownPolicy  = errors.New("unknown storage policy")
+	ErrNoDestination  = errors.New("no storage destination given")
+	ErrPartialFailure = errors.New("some of the storage destinations failed")
+)
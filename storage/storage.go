@@ -2,39 +2,204 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"io"
-	"time"
+	"strings"
+	"sync"
 
 	"github.com/aweris/postgres-data-dump/internal/log"
 	"github.com/aweris/postgres-data-dump/storage/backend"
+	"github.com/pkg/errors"
 )
 
 const (
-	DefaultOperationTimeout = 3 * time.Minute
+	// size of the buffer used while copying source to destinations.
+	teeBufferSize = 32 * 1024
 )
 
 // Storage is a place that files can be written to and read from.
 type Storage interface {
 	// Put writes contents of io.Reader to remote storage at given key location.
-	Put(p string, r io.Reader) error
+	Put(ctx context.Context, p string, r io.Reader) error
+
+	// Get returns contents of the object at given key location from the first destination having it.
+	Get(ctx context.Context, p string) (io.ReadCloser, error)
+}
+
+// Destination is a named backend that storage writes to.
+type Destination struct {
+	Name    string
+	Backend backend.Backend
 }
 
 // Default Storage implementation.
 type storage struct {
-	logger  log.Logger
-	backend backend.Backend
-	timeout time.Duration
+	logger       log.Logger
+	destinations []Destination
+	policy       string
 }
 
-// New create a new default storage.
-func New(logger log.Logger, b backend.Backend, timeout time.Duration) Storage {
-	return &storage{logger, b, timeout}
+// New create a new default storage writing to all given destinations.
+func New(logger log.Logger, cfg Config, destinations ...Destination) (Storage, error) {
+	switch cfg.Policy {
+	case PolicyAllMustSucceed, PolicyAtLeastOne:
+	default:
+		return nil, ErrUnknownPolicy
+	}
+
+	if len(destinations) == 0 {
+		return nil, ErrNoDestination
+	}
+
+	return &storage{logger, destinations, cfg.Policy}, nil
 }
 
-// Put writes contents of io.Reader to remote storage at given key location.
-func (s *storage) Put(p string, r io.Reader) error {
-	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+// Put writes contents of io.Reader to all destinations at given key location concurrently.
+func (s *storage) Put(ctx context.Context, p string, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	return s.backend.Put(ctx, p, r)
+	var (
+		wg      sync.WaitGroup
+		writers = make([]*io.PipeWriter, len(s.destinations))
+		results = make([]error, len(s.destinations))
+	)
+
+	for i, d := range s.destinations {
+		pr, pw := io.Pipe()
+		writers[i] = pw
+
+		wg.Add(1)
+
+		go func(i int, d Destination, pr *io.PipeReader) {
+			defer wg.Done()
+
+			err := d.Backend.Put(ctx, p, pr)
+			results[i] = err
+
+			// unblock the writer in case backend returned without consuming the reader
+			if err != nil {
+				_ = pr.CloseWithError(err)
+
+				if s.policy == PolicyAllMustSucceed {
+					cancel()
+				}
+
+				return
+			}
+
+			_ = pr.Close()
+		}(i, d, pr)
+	}
+
+	teeErr := s.tee(r, writers)
+
+	wg.Wait()
+
+	return s.evaluate(p, teeErr, results)
+}
+
+// Get returns contents of the object at given key location from the first destination having it.
+func (s *storage) Get(ctx context.Context, p string) (io.ReadCloser, error) {
+	failed := make([]string, 0)
+
+	for _, d := range s.destinations {
+		rc, err := d.Backend.Get(ctx, p)
+		if err == nil {
+			s.logger.Debug("msg", "object get", "backend", d.Name, "key", p)
+
+			return rc, nil
+		}
+
+		s.logger.Warn("msg", "failed to get object", "backend", d.Name, "key", p, "error", err)
+
+		failed = append(failed, fmt.Sprintf("%s: %v", d.Name, err))
+	}
+
+	return nil, errors.Errorf("can't get %s from any destination: %s", p, strings.Join(failed, "; "))
+}
+
+// tee copies contents of the reader to all writers. Writers failed during the copy are dropped, remaining writers
+// continue to receive the data unless policy requires all of them to succeed.
+func (s *storage) tee(r io.Reader, writers []*io.PipeWriter) error {
+	active := len(writers)
+	buf := make([]byte, teeBufferSize)
+
+	closeAll := func(err error) {
+		for _, w := range writers {
+			if w != nil {
+				_ = w.CloseWithError(err)
+			}
+		}
+	}
+
+	for {
+		n, rerr := r.Read(buf)
+
+		if n > 0 {
+			for i, w := range writers {
+				if w == nil {
+					continue
+				}
+
+				if _, err := w.Write(buf[:n]); err != nil {
+					s.logger.Debug("msg", "dropping destination", "backend", s.destinations[i].Name, "error", err)
+
+					writers[i] = nil
+					active--
+
+					if s.policy == PolicyAllMustSucceed {
+						err = errors.Wrapf(err, "aborted, destination %s failed", s.destinations[i].Name)
+						closeAll(err)
+
+						return err
+					}
+				}
+			}
+
+			if active == 0 {
+				return errors.New("all destinations failed")
+			}
+		}
+
+		if rerr == io.EOF {
+			closeAll(nil)
+			return nil
+		}
+
+		if rerr != nil {
+			closeAll(rerr)
+			return errors.Wrap(rerr, "failed to read source")
+		}
+	}
+}
+
+// evaluate logs per destination results and applies storage policy to them.
+func (s *storage) evaluate(p string, teeErr error, results []error) error {
+	failed := make([]string, 0)
+
+	for i, err := range results {
+		name := s.destinations[i].Name
+
+		if err != nil {
+			s.logger.Error("msg", "failed to put object", "backend", name, "key", p, "error", err)
+
+			failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+
+			continue
+		}
+
+		s.logger.Info("msg", "object put", "backend", name, "key", p)
+	}
+
+	switch {
+	case len(failed) == 0 && teeErr != nil:
+		return teeErr
+	case len(failed) == 0:
+		return nil
+	case len(failed) < len(results) && s.policy == PolicyAtLeastOne:
+		return ErrPartialFailure
+	default:
+		return errors.Errorf("%d of %d destinations failed: %s", len(failed), len(results), strings.Join(failed, "; "))
+	}
 }
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aweris/postgres-data-dump/internal/log"
+	"github.com/pkg/errors"
+)
+
+// fakeBackend keeps the contents put, or fails after reading failAfter bytes if failAfter isn't negative.
+type fakeBackend struct {
+	failAfter int64
+	data      []byte
+}
+
+func (b *fakeBackend) Put(_ context.Context, _ string, r io.Reader) error {
+	if b.failAfter < 0 {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		b.data = data
+
+		return nil
+	}
+
+	if _, err := io.CopyN(ioutil.Discard, r, b.failAfter); err != nil {
+		return err
+	}
+
+	return errors.New("backend failed")
+}
+
+func (b *fakeBackend) Get(_ context.Context, _ string) (io.ReadCloser, error) {
+	if b.data == nil {
+		return nil, os.ErrNotExist
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b.data)), nil
+}
+
+func newTestStorage(t *testing.T, policy string, backends ...*fakeBackend) *storage {
+	logger, err := log.NewLogger(log.LevelInfo, log.FormatFmt, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	destinations := make([]Destination, 0, len(backends))
+	for i, b := range backends {
+		destinations = append(destinations, Destination{Name: string(rune('a' + i)), Backend: b})
+	}
+
+	s, err := New(logger, Config{Policy: policy}, destinations...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.(*storage)
+}
+
+// content is larger than the tee buffer, so the failing destinations are dropped in the middle of the copy.
+var content = bytes.Repeat([]byte("0123456789abcdef\n"), 3*teeBufferSize/16)
+
+func TestPut(t *testing.T) {
+	a, b := &fakeBackend{failAfter: -1}, &fakeBackend{failAfter: -1}
+	s := newTestStorage(t, PolicyAllMustSucceed, a, b)
+
+	if err := s.Put(context.Background(), "dump.sql", bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(a.data, content) || !bytes.Equal(b.data, content) {
+		t.Fatalf("destinations got %d and %d bytes, want %d", len(a.data), len(b.data), len(content))
+	}
+}
+
+func TestPutPartialFailure(t *testing.T) {
+	a, b := &fakeBackend{failAfter: teeBufferSize}, &fakeBackend{failAfter: -1}
+	s := newTestStorage(t, PolicyAtLeastOne, a, b)
+
+	if err := s.Put(context.Background(), "dump.sql", bytes.NewReader(content)); err != ErrPartialFailure {
+		t.Fatalf("expected partial failure, got %v", err)
+	}
+
+	// remaining destination receives all data
+	if !bytes.Equal(b.data, content) {
+		t.Fatalf("destination got %d bytes, want %d", len(b.data), len(content))
+	}
+
+	rc, err := s.Get(context.Background(), "dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data, err := ioutil.ReadAll(rc); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("get returned %d bytes, %v", len(data), err)
+	}
+}
+
+func TestPutFailure(t *testing.T) {
+	tests := map[string][]*fakeBackend{
+		PolicyAllMustSucceed: {{failAfter: teeBufferSize}, {failAfter: -1}},
+		PolicyAtLeastOne:     {{failAfter: 0}, {failAfter: teeBufferSize}},
+	}
+
+	for policy, backends := range tests {
+		s := newTestStorage(t, policy, backends...)
+
+		err := s.Put(context.Background(), "dump.sql", bytes.NewReader(content))
+		if err == nil || !strings.Contains(err.Error(), "destinations failed") {
+			t.Errorf("%s: unexpected error %v", policy, err)
+		}
+
+		if _, err := s.Get(context.Background(), "dump.sql"); err == nil {
+			t.Errorf("%s: expected get to fail", policy)
+		}
+	}
+}
+
+func TestPutSourceFailure(t *testing.T) {
+	s := newTestStorage(t, PolicyAllMustSucceed, &fakeBackend{failAfter: -1})
+
+	r := io.MultiReader(bytes.NewReader(content), iotestErrReader{})
+
+	// destinations see the source error, nothing is stored
+	err := s.Put(context.Background(), "dump.sql", r)
+	if err == nil || !strings.Contains(err.Error(), "a: source failed") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := s.Get(context.Background(), "dump.sql"); err == nil {
+		t.Fatal("expected get to fail")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	failed := errors.New("failed")
+	teeErr := errors.New("tee failed")
+
+	tests := []struct {
+		policy  string
+		teeErr  error
+		results []error
+		want    string
+	}{
+		{PolicyAllMustSucceed, nil, []error{nil, nil}, ""},
+		{PolicyAllMustSucceed, teeErr, []error{nil, nil}, "tee failed"},
+		{PolicyAllMustSucceed, nil, []error{nil, failed}, "1 of 2 destinations failed: b: failed"},
+		{PolicyAtLeastOne, nil, []error{failed, nil}, ErrPartialFailure.Error()},
+		{PolicyAtLeastOne, teeErr, []error{failed, failed}, "2 of 2 destinations failed: a: failed; b: failed"},
+	}
+
+	for _, tt := range tests {
+		s := newTestStorage(t, tt.policy, &fakeBackend{}, &fakeBackend{})
+
+		err := s.evaluate("dump.sql", tt.teeErr, tt.results)
+		if got := errorString(err); got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.policy, tt.results, got, tt.want)
+		}
+	}
+}
+
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) {
+	return 0, errors.New("source failed")
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}